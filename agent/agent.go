@@ -2,26 +2,29 @@ package agent
 
 import (
 	"bytes"
-	"container/ring"
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
-	"math/rand"
+	"net/http"
 	"os"
-	"reflect"
 	"runtime"
 	"runtime/pprof"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/xiaojiaoyu100/profiler/log"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 
 	gprofile "github.com/google/pprof/profile"
 	"github.com/sirupsen/logrus"
 	"github.com/xiaojiaoyu100/cast"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest/ingestpb"
 	"github.com/xiaojiaoyu100/profiler/profile"
+	"github.com/xiaojiaoyu100/profiler/tlsutil"
+	"google.golang.org/grpc/credentials"
 )
 
 const (
@@ -31,9 +34,31 @@ const (
 type Agent struct {
 	o      *Option
 	c      *cast.Cast
+	rc     *retryClient // replaces c over tls
+	conn   *grpc.ClientConn
+	pc     ingestpb.ProfileServiceClient
 	logger *zap.Logger
 	stop   chan struct{}
 	done   chan struct{}
+	// cpuMu is held while the cpu profile is collected.
+	cpuMu sync.Mutex
+	// last keeps the previous snapshot of every cumulative profile type.
+	mu   sync.Mutex
+	last map[profile.Type]*gprofile.Profile
+	// rates are the sampling rates before Start, restored on Stop.
+	rates runtimeRates
+	spool *spool
+	// batch keeps the uploads waiting to be sent together.
+	batchMu sync.Mutex
+	batch   []*upload
+	flush   chan struct{}
+}
+
+// runtimeRates are the sampling rates of the runtime that affect the profiles.
+type runtimeRates struct {
+	blockProfileRate     int
+	mutexProfileFraction int
+	memProfileRate       int
 }
 
 type Setter func(o *Option) error
@@ -45,6 +70,51 @@ func WithCollectorAddr(addr string) Setter {
 	}
 }
 
+// WithGRPCTransport uploads the profiles to the gRPC ProfileService of the collector
+// at target instead of over http, opts are passed to grpc.Dial and must set
+// the transport credentials, e.g. grpc.WithInsecure().
+func WithGRPCTransport(target string, opts ...grpc.DialOption) Setter {
+	return func(o *Option) error {
+		if target == "" {
+			return errors.New("no grpc target provided")
+		}
+		o.GRPCTarget = target
+		o.GRPCDialOptions = append(o.GRPCDialOptions, opts...)
+		return nil
+	}
+}
+
+// WithAPIKey authenticates the uploads with key, the collector only accepts
+// the profiles of the services the key is scoped to.
+func WithAPIKey(key string) Setter {
+	return func(o *Option) error {
+		if key == "" {
+			return errors.New("no api key provided")
+		}
+		o.APIKey = key
+		return nil
+	}
+}
+
+// WithTLS verifies the collector with the ca bundle in caFile, the system roots
+// if it is empty, and presents the client certificate in certFile and keyFile
+// if they are given, which the collector may use as the identity of the service.
+// The files are reloaded once they change.
+func WithTLS(caFile, certFile, keyFile string) Setter {
+	return func(o *Option) error {
+		cfg, err := tlsutil.ClientConfig(&tlsutil.Option{
+			CAFile:   caFile,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		})
+		if err != nil {
+			return fmt.Errorf("fail to load the tls config: %w", err)
+		}
+		o.TLSConfig = cfg
+		return nil
+	}
+}
+
 func WithService(service string, serviceVersion string) Setter {
 	return func(o *Option) error {
 		o.Service = service
@@ -53,6 +123,23 @@ func WithService(service string, serviceVersion string) Setter {
 	}
 }
 
+// WithTags attaches tags to every uploaded profile, like region, cluster or pod,
+// they can be used to filter the profiles in the collector.
+func WithTags(tags map[string]string) Setter {
+	return func(o *Option) error {
+		if o.Tags == nil {
+			o.Tags = make(map[string]string, len(tags))
+		}
+		for k, v := range tags {
+			if k == "" || strings.Contains(k, "=") {
+				return fmt.Errorf("invalid tag key: %q", k)
+			}
+			o.Tags[k] = v
+		}
+		return nil
+	}
+}
+
 func WithBreakPeriod(d time.Duration) Setter {
 	return func(o *Option) error {
 		o.BreakPeriod = d
@@ -96,6 +183,42 @@ func WithMutexProfiling(en bool) Setter {
 	}
 }
 
+// WithBlockProfileRate sets the rate passed to runtime.SetBlockProfileRate
+// while the block profiling is enabled.
+func WithBlockProfileRate(rate int) Setter {
+	return func(o *Option) error {
+		if rate <= 0 {
+			return errors.New("block profile rate must be positive")
+		}
+		o.BlockProfileRate = rate
+		return nil
+	}
+}
+
+// WithMutexProfileFraction sets the fraction passed to runtime.SetMutexProfileFraction
+// while the mutex profiling is enabled.
+func WithMutexProfileFraction(fraction int) Setter {
+	return func(o *Option) error {
+		if fraction <= 0 {
+			return errors.New("mutex profile fraction must be positive")
+		}
+		o.MutexProfileFraction = fraction
+		return nil
+	}
+}
+
+// WithMemProfileRate sets runtime.MemProfileRate, it should be called
+// as early as possible in the program.
+func WithMemProfileRate(rate int) Setter {
+	return func(o *Option) error {
+		if rate <= 0 {
+			return errors.New("mem profile rate must be positive")
+		}
+		o.MemProfileRate = rate
+		return nil
+	}
+}
+
 func WithGoroutineProfiling(en bool) Setter {
 	return func(o *Option) error {
 		o.GoroutineProfiling = en
@@ -110,6 +233,63 @@ func WithThreadCreateProfiling(en bool) Setter {
 	}
 }
 
+// WithSchedule sets how often and how long an enabled profile type is collected,
+// a zero Interval or Duration falls back to BreakPeriod or CPUProfilingPeriod.
+func WithSchedule(profileType profile.Type, schedule Schedule) Setter {
+	return func(o *Option) error {
+		if schedule.Interval < 0 || schedule.Duration < 0 || schedule.Jitter < 0 {
+			return errors.New("negative schedule")
+		}
+		if o.Schedules == nil {
+			o.Schedules = make(map[profile.Type]Schedule)
+		}
+		o.Schedules[profileType] = schedule
+		return nil
+	}
+}
+
+// WithBatch sends up to size profiles in one request to the /v2/profile endpoint
+// of the collector, a batch is sent at the latest wait after its first profile.
+func WithBatch(size int, wait time.Duration) Setter {
+	return func(o *Option) error {
+		if size <= 0 || wait <= 0 {
+			return errors.New("batch size and wait must be positive")
+		}
+		o.BatchSize = size
+		o.BatchWait = wait
+		return nil
+	}
+}
+
+// WithSpool keeps the uploads that fail to reach the collector in dir until they
+// are resent, an empty dir keeps them in memory, which is the default.
+// Zero maxBytes or maxAge leaves the default.
+func WithSpool(dir string, maxBytes int64, maxAge time.Duration) Setter {
+	return func(o *Option) error {
+		if maxBytes < 0 || maxAge < 0 {
+			return errors.New("negative spool limit")
+		}
+		o.SpoolDir = dir
+		if maxBytes > 0 {
+			o.SpoolMaxBytes = maxBytes
+		}
+		if maxAge > 0 {
+			o.SpoolMaxAge = maxAge
+		}
+		return nil
+	}
+}
+
+// WithDeltaProfiling uploads the difference between two snapshots of
+// the allocs, block and mutex profiles instead of the cumulative snapshot,
+// the first snapshot of each type is not uploaded.
+func WithDeltaProfiling(en bool) Setter {
+	return func(o *Option) error {
+		o.DeltaProfiling = en
+		return nil
+	}
+}
+
 func New(ff ...Setter) (*Agent, error) {
 	option := &Option{}
 	option.goVersion = runtime.Version()
@@ -118,13 +298,19 @@ func New(ff ...Setter) (*Agent, error) {
 	option.CPUProfiling = true
 	option.HeapProfiling = true
 	option.AllocsProfiling = true
+	option.DeltaProfiling = true
+	option.BlockProfileRate = defaultBlockProfileRate
+	option.MutexProfileFraction = defaultMutexProfileFraction
+	option.TriggerInterval = defaultTriggerInterval
+	option.SpoolMaxBytes = defaultSpoolMaxBytes
+	option.SpoolMaxAge = defaultSpoolMaxAge
 
 	for _, f := range ff {
 		if err := f(option); err != nil {
 			return nil, err
 		}
 	}
-	if option.CollectorAddr == "" {
+	if option.CollectorAddr == "" && option.GRPCTarget == "" {
 		return nil, errors.New("no collector addr provided")
 	}
 	if option.Service == "" {
@@ -134,21 +320,54 @@ func New(ff ...Setter) (*Agent, error) {
 	if option.ServiceVersion == "" {
 		return nil, errors.New("no service version provided")
 	}
+	option.initSchedules()
 
-	c, err := cast.New(
-		cast.WithBaseURL(option.CollectorAddr),
-		cast.AddCircuitConfig(agentCircuit),
-		cast.WithDefaultCircuit(agentCircuit),
-		cast.WithHTTPClientTimeout(time.Second*60),
-		cast.WithLogLevel(logrus.WarnLevel),
-		cast.WithRetry(2),
-		cast.WithExponentialBackoffDecorrelatedJitterStrategy(
-			time.Millisecond*200,
-			time.Millisecond*500,
-		),
+	var (
+		c    *cast.Cast
+		rc   *retryClient
+		conn *grpc.ClientConn
+		err  error
 	)
-	if err != nil {
-		return nil, fmt.Errorf("create cast err: %w", err)
+	if option.GRPCTarget != "" {
+		dialOptions := option.GRPCDialOptions
+		if option.TLSConfig != nil {
+			dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(option.TLSConfig)))
+		}
+		if option.APIKey != "" {
+			dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(apiKeyCredentials(option.APIKey)))
+		}
+		// The dial does not block, the connection is made on the first upload.
+		conn, err = grpc.Dial(option.GRPCTarget, dialOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("fail to dial grpc: %w", err)
+		}
+	} else if option.TLSConfig == nil {
+		setters := []cast.Setter{
+			cast.WithBaseURL(option.CollectorAddr),
+			cast.AddCircuitConfig(agentCircuit, agentCircuitConfig()),
+			cast.WithDefaultCircuit(agentCircuit),
+			cast.WithHTTPClientTimeout(uploadTimeout),
+			cast.WithLogLevel(logrus.WarnLevel),
+			cast.WithRetry(uploadRetry),
+			cast.WithExponentialBackoffDecorrelatedJitterStrategy(
+				uploadBackoffBase,
+				uploadBackoffCap,
+			),
+		}
+		if option.APIKey != "" {
+			setters = append(setters, cast.SetHeader("Authorization", "Bearer "+option.APIKey))
+		}
+		c, err = cast.New(setters...)
+		if err != nil {
+			return nil, fmt.Errorf("create cast err: %w", err)
+		}
+	} else {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = option.TLSConfig
+		rc = newRetryClient(&http.Client{
+			Transport: transport,
+			Timeout:   uploadTimeout,
+		})
 	}
 
 	conf := zap.NewProductionConfig()
@@ -159,82 +378,108 @@ func New(ff ...Setter) (*Agent, error) {
 		return nil, fmt.Errorf("fail to create a logger: %w", err)
 	}
 
+	sp, err := newSpool(option.SpoolDir, option.SpoolMaxBytes, option.SpoolMaxAge)
+	if err != nil {
+		logger.Warn("fail to use the spool dir, spool in memory instead", zap.Error(err))
+		sp, _ = newSpool("", option.SpoolMaxBytes, option.SpoolMaxAge)
+	}
+
 	agent := &Agent{
 		o:      option,
 		c:      c,
+		rc:     rc,
+		conn:   conn,
 		logger: logger,
 		stop:   make(chan struct{}),
 		done:   make(chan struct{}),
+		last:   make(map[profile.Type]*gprofile.Profile),
+		spool:  sp,
+		flush:  make(chan struct{}, 1),
+	}
+	if conn != nil {
+		agent.pc = ingestpb.NewProfileServiceClient(conn)
 	}
 	return agent, nil
 }
 
 func (a *Agent) Start(ctx context.Context) {
+	a.applyRates()
 	go a.onSchedule(ctx)
 }
 
-func adjust(t time.Duration) time.Duration {
-	return t + time.Duration(rand.Intn(5)+1)*time.Second
+// applyRates turns on the sampling the enabled profiles rely on.
+func (a *Agent) applyRates() {
+	// The block profile rate can not be read, it is off unless someone turns it on.
+	a.rates.mutexProfileFraction = runtime.SetMutexProfileFraction(-1)
+	a.rates.memProfileRate = runtime.MemProfileRate
+	if a.o.BlockProfiling {
+		runtime.SetBlockProfileRate(a.o.BlockProfileRate)
+	}
+	if a.o.MutexProfiling {
+		runtime.SetMutexProfileFraction(a.o.MutexProfileFraction)
+	}
+	if a.o.MemProfileRate > 0 {
+		runtime.MemProfileRate = a.o.MemProfileRate
+	}
 }
 
-type ReceiveProfileReq struct {
-	Service        string `json:"service"`
-	ServiceVersion string `json:"service_version"`
-	Host           string `json:"host"`
-	GoVersion      string `json:"go_version"`
-	ProfileType    string `json:"profile_type"`
-	Profile        string `json:"profile"`
-	SendTime       int64  `json:"send_time"`
-	CreateTime     int64  `json:"create_time"`
-}
-
-func (a *Agent) initRing() *ring.Ring {
-	ret := make(map[string]bool)
-	t := reflect.TypeOf(*a.o)
-	v := reflect.ValueOf(a.o).Elem()
-	for i := 0; i < t.NumField(); i++ {
-		tag := t.Field(i).Tag.Get("profile")
-		if tag == "" {
-			continue
-		}
-		if v.Field(i).Type().Kind() != reflect.Bool {
-			continue
-		}
-		en := v.Field(i).Bool()
-		if !en {
-			continue
-		}
-		ret[tag] = true
+func (a *Agent) restoreRates() {
+	if a.o.BlockProfiling {
+		runtime.SetBlockProfileRate(a.rates.blockProfileRate)
 	}
-	var ll = []profile.Type{
-		profile.TypeCPU,
-		profile.TypeHeap,
-		profile.TypeAllocs,
-		profile.TypeBlock,
-		profile.TypeMutex,
-		profile.TypeGoroutine,
-		profile.TypeThreadCreate,
+	if a.o.MutexProfiling {
+		runtime.SetMutexProfileFraction(a.rates.mutexProfileFraction)
 	}
-	var r = ring.New(len(ret))
-	for i := 0; i < len(ll); i++ {
-		_, ok := ret[ll[i].String()]
-		if !ok {
-			continue
-		}
-		r.Value = ll[i]
-		r = r.Next()
+	if a.o.MemProfileRate > 0 {
+		runtime.MemProfileRate = a.rates.memProfileRate
 	}
-	return r
 }
 
-func (a *Agent) collectAndSend(ctx context.Context, buf *bytes.Buffer, r *ring.Ring) error {
-	profileType := r.Value.(profile.Type)
+// activeRates returns the sampling rates in effect.
+func (a *Agent) activeRates() runtimeRates {
+	var rates runtimeRates
+	if a.o.BlockProfiling {
+		rates.blockProfileRate = a.o.BlockProfileRate
+	}
+	rates.mutexProfileFraction = runtime.SetMutexProfileFraction(-1)
+	rates.memProfileRate = runtime.MemProfileRate
+	return rates
+}
+
+type ReceiveProfileReq struct {
+	Service        string            `json:"service"`
+	ServiceVersion string            `json:"service_version"`
+	Host           string            `json:"host"`
+	GoVersion      string            `json:"go_version"`
+	ProfileType    string            `json:"profile_type"`
+	Profile        string            `json:"profile"`
+	SendTime       int64             `json:"send_time"`
+	CreateTime     int64             `json:"create_time"`
+	Delta          bool              `json:"delta"`
+	Tags           map[string]string `json:"tags,omitempty"`
+	Triggered      bool              `json:"triggered"`
+	TriggerReason  string            `json:"trigger_reason,omitempty"`
+	// The sampling rates in effect, see runtime.SetBlockProfileRate,
+	// runtime.SetMutexProfileFraction and runtime.MemProfileRate.
+	BlockProfileRate     int `json:"block_profile_rate"`
+	MutexProfileFraction int `json:"mutex_profile_fraction"`
+	MemProfileRate       int `json:"mem_profile_rate"`
+}
+
+// errNotCollected marks the errors of collectAndSend before the profile is collected.
+var errNotCollected = errors.New("profile is not collected")
+
+// collect writes a profile to buf, the cpu profiles wait for each other,
+// as the runtime collects one at a time.
+func (a *Agent) collect(ctx context.Context, buf *bytes.Buffer, profileType profile.Type) error {
 	switch profileType {
 	case profile.TypeCPU:
+		a.cpuMu.Lock()
+		defer a.cpuMu.Unlock()
 		if err := pprof.StartCPUProfile(buf); err != nil {
 			return fmt.Errorf("fail to start cpu profile: %w", err)
 		}
-		block(ctx, a.o.CPUProfilingPeriod)
+		block(ctx, a.cpuDuration())
 		pprof.StopCPUProfile()
 	case profile.TypeHeap,
 		profile.TypeAllocs,
@@ -253,6 +498,29 @@ func (a *Agent) collectAndSend(ctx context.Context, buf *bytes.Buffer, r *ring.R
 			return fmt.Errorf("fail to write profile[%s]: %w", profileType.String(), err)
 		}
 	}
+	return nil
+}
+
+// collectAndSend collects a profile and uploads it, reason is why it is triggered,
+// it is empty for the scheduled collection.
+func (a *Agent) collectAndSend(ctx context.Context, buf *bytes.Buffer, profileType profile.Type, reason string) error {
+	if err := a.collect(ctx, buf, profileType); err != nil {
+		return fmt.Errorf("%w: %v", errNotCollected, err)
+	}
+
+	var delta bool
+	// The triggered snapshots are uploaded as they are, so they do not
+	// disturb the deltas of the schedule.
+	if a.o.DeltaProfiling && profileType.Cumulative() && reason == "" {
+		ok, err := a.delta(profileType, buf)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		delta = true
+	}
 
 	var body ReceiveProfileReq
 	body.Service = a.o.Service
@@ -264,13 +532,19 @@ func (a *Agent) collectAndSend(ctx context.Context, buf *bytes.Buffer, r *ring.R
 	body.Host = hostname
 	body.GoVersion = a.o.goVersion
 	body.ProfileType = profileType.String()
-
-	pf := base64.StdEncoding.EncodeToString(buf.Bytes())
-	if len(pf) == 0 {
-		return fmt.Errorf("profile buffer is zero: %s", profileType.String())
+	body.Tags = a.o.Tags
+	body.Delta = delta
+	body.Triggered = reason != ""
+	body.TriggerReason = reason
+	rates := a.activeRates()
+	body.BlockProfileRate = rates.blockProfileRate
+	body.MutexProfileFraction = rates.mutexProfileFraction
+	body.MemProfileRate = rates.memProfileRate
+
+	if buf.Len() == 0 {
+		return fmt.Errorf("%w: profile buffer is zero: %s", errNotCollected, profileType.String())
 	}
 
-	body.Profile = pf
 	body.SendTime = time.Now().Unix()
 	pp, err := gprofile.ParseData(buf.Bytes())
 	if err != nil {
@@ -278,63 +552,54 @@ func (a *Agent) collectAndSend(ctx context.Context, buf *bytes.Buffer, r *ring.R
 	}
 	body.CreateTime = pp.TimeNanos / 1e9
 
-	req := a.c.NewRequest().Post().WithPath("/v1/profile").WithJSONBody(&body)
-	resp, err := a.c.Do(ctx, req)
-	if err != nil {
-		return fmt.Errorf("fail to send profile[%s]: %w", profileType.String(), err)
+	u := &upload{
+		meta: body,
+		data: append([]byte(nil), buf.Bytes()...),
 	}
-	if !resp.StatusOk() {
-		return fmt.Errorf("profile[%s] response is not ok: %s", profileType.String(), resp.String())
+	if a.o.BatchSize > 1 {
+		a.enqueue(u)
+		return nil
+	}
+	if err := a.deliver(ctx, []*upload{u}); err != nil {
+		return fmt.Errorf("fail to send profile[%s]: %w", profileType.String(), err)
 	}
 	return nil
 }
 
-func (a *Agent) prepareNextRound(t *time.Timer, buf *bytes.Buffer, r *ring.Ring, pt profile.Type) *ring.Ring {
-	buf.Reset()
-	r = r.Next()
-	if r.Value.(profile.Type) == pt {
-		t.Reset(adjust(a.o.BreakPeriod))
+// cpuDuration is how long the cpu profile is collected.
+func (a *Agent) cpuDuration() time.Duration {
+	if s, ok := a.o.Schedules[profile.TypeCPU]; ok {
+		return s.Duration
 	}
-	t.Reset(adjust(0))
-	return r
+	return a.o.CPUProfilingPeriod
 }
 
-func (a *Agent) onSchedule(ctx context.Context) {
-	defer close(a.done)
-
-	r := a.initRing()
-	if r == nil {
-		return
+// delta replaces the snapshot in buf with its difference from the previous one
+// like net/http/pprof does for ?seconds=, it returns false for the first snapshot.
+func (a *Agent) delta(profileType profile.Type, buf *bytes.Buffer) (bool, error) {
+	cur, err := gprofile.ParseData(buf.Bytes())
+	if err != nil {
+		return false, fmt.Errorf("fail to parse profile[%s] data: %w", profileType.String(), err)
 	}
-
-	pt := r.Value.(profile.Type)
-
-	ctx, cancel := context.WithCancel(ctx)
-	go func() {
-		<-a.stop
-		cancel()
-	}()
-
-	ti := time.NewTimer(adjust(0))
-	var buf bytes.Buffer
-	for {
-		select {
-		case <-a.stop:
-			{
-				if !ti.Stop() {
-					<-ti.C
-					return
-				}
-			}
-		case <-ti.C:
-			{
-				if err := a.collectAndSend(ctx, &buf, r); err != nil {
-					a.logger.Warn(fmt.Sprintf("fail to collect and send: %v", r.Value), zap.Error(err))
-				}
-				r = a.prepareNextRound(ti, &buf, r, pt)
-			}
-		}
+	a.mu.Lock()
+	prev := a.last[profileType]
+	a.last[profileType] = cur.Copy()
+	a.mu.Unlock()
+	if prev == nil {
+		return false, nil
+	}
+	prev.Scale(-1)
+	p, err := gprofile.Merge([]*gprofile.Profile{prev, cur})
+	if err != nil {
+		return false, fmt.Errorf("fail to merge profile[%s]: %w", profileType.String(), err)
 	}
+	p.TimeNanos = cur.TimeNanos
+	p.DurationNanos = cur.TimeNanos - prev.TimeNanos
+	buf.Reset()
+	if err := p.Write(buf); err != nil {
+		return false, fmt.Errorf("fail to write profile[%s] delta: %w", profileType.String(), err)
+	}
+	return true, nil
 }
 
 func block(ctx context.Context, t time.Duration) {
@@ -357,4 +622,10 @@ func block(ctx context.Context, t time.Duration) {
 func (a *Agent) Stop() {
 	close(a.stop)
 	<-a.done
+	a.restoreRates()
+	if a.conn != nil {
+		if err := a.conn.Close(); err != nil {
+			a.logger.Warn("fail to close the grpc connection", zap.Error(err))
+		}
+	}
 }
@@ -1,7 +1,192 @@
 package agent
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime/metrics"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/profile"
+)
 
 func TestNew(t *testing.T) {
 
 }
+
+func TestSchedule(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		counts = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ReceiveProfileReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		counts[req.ProfileType]++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	a, err := New(
+		WithCollectorAddr(srv.URL),
+		WithService("svc", "v1"),
+		WithAllocsProfiling(false),
+		WithSchedule(profile.TypeCPU, Schedule{Interval: time.Second, Duration: 500 * time.Millisecond}),
+		WithSchedule(profile.TypeHeap, Schedule{Interval: 100 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start(context.Background())
+	time.Sleep(800 * time.Millisecond)
+	a.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// The heap profile is collected while the cpu profile is running.
+	if counts["heap"] < 3 || counts["cpu"] != 1 {
+		t.Fatalf("unexpected uploads: %v", counts)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		reqs []ReceiveProfileReq
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ReceiveProfileReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	a, err := New(
+		WithCollectorAddr(srv.URL),
+		WithService("svc", "v1"),
+		WithCPUProfiling(false, 0),
+		WithHeapProfiling(false),
+		WithAllocsProfiling(false),
+		WithTriggerInterval(50*time.Millisecond),
+		WithTrigger(Trigger{Metric: MetricGoroutine, Threshold: 1, Cooldown: time.Hour}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start(context.Background())
+	time.Sleep(300 * time.Millisecond)
+	a.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reqs) != 1 || reqs[0].ProfileType != "goroutine" || !reqs[0].Triggered || reqs[0].TriggerReason == "" {
+		t.Fatalf("unexpected uploads: %+v", reqs)
+	}
+}
+
+func TestCollectCPU(t *testing.T) {
+	a, err := New(
+		WithCollectorAddr("http://127.0.0.1:0"),
+		WithService("svc", "v1"),
+		WithSchedule(profile.TypeCPU, Schedule{Interval: time.Hour, Duration: 100 * time.Millisecond}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A triggered cpu profile waits for the scheduled one instead of failing.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := new(bytes.Buffer)
+			if err := a.collect(context.Background(), buf, profile.TypeCPU); err != nil {
+				t.Error(err)
+			}
+			if buf.Len() == 0 {
+				t.Error("cpu profile is empty")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTriggerReason(t *testing.T) {
+	tr := Trigger{Metric: MetricHeap, IncreasePercent: 50}
+	if err := tr.init(); err != nil {
+		t.Fatal(err)
+	}
+	if r := tr.reason(140, 100, true); r != "" {
+		t.Fatalf("40%% increase fires: %s", r)
+	}
+	if r := tr.reason(150, 100, true); r == "" {
+		t.Fatal("50% increase does not fire")
+	}
+	if err := (&Trigger{Metric: MetricCPU}).init(); err == nil {
+		t.Fatal("trigger without any rule is accepted")
+	}
+}
+
+func TestTriggerThreshold(t *testing.T) {
+	r := new(metricReader)
+	cpu := Trigger{Metric: MetricCPU, Threshold: 80}
+	heap := Trigger{Metric: MetricHeap, Threshold: 1 << 30}
+	for _, tr := range []*Trigger{&cpu, &heap} {
+		if err := tr.init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 4 procs for 10s give 40 cpu seconds.
+	if v := r.compute(map[string]float64{sampleCPUUser: 10, sampleCPUTotal: 40}); len(v) != 0 {
+		t.Fatalf("cpu usage without a previous read: %v", v)
+	}
+	v := r.compute(map[string]float64{sampleCPUUser: 30, sampleCPUTotal: 80, sampleHeapObjects: 1 << 29})
+	if v[MetricCPU] != 50 {
+		t.Fatalf("cpu usage = %v, want 50", v[MetricCPU])
+	}
+	if reason := cpu.reason(v[MetricCPU], 0, false); reason != "" {
+		t.Fatalf("cpu 50%% fires: %s", reason)
+	}
+	if reason := heap.reason(v[MetricHeap], 0, false); reason != "" {
+		t.Fatalf("heap 512MB fires: %s", reason)
+	}
+
+	v = r.compute(map[string]float64{sampleCPUUser: 66, sampleCPUTotal: 120, sampleHeapObjects: 1 << 30})
+	if v[MetricCPU] != 90 {
+		t.Fatalf("cpu usage = %v, want 90", v[MetricCPU])
+	}
+	if reason := cpu.reason(v[MetricCPU], 0, false); reason == "" {
+		t.Fatal("cpu 90% does not fire")
+	}
+	if reason := heap.reason(v[MetricHeap], 0, false); reason == "" {
+		t.Fatal("heap 1GB does not fire")
+	}
+}
+
+func TestTriggerUnsupportedMetric(t *testing.T) {
+	defer func(f func() []metrics.Description) {
+		runtimeMetrics = f
+	}(runtimeMetrics)
+	runtimeMetrics = func() []metrics.Description {
+		return []metrics.Description{{Name: sampleGoroutines}, {Name: sampleHeapObjects}}
+	}
+	option := new(Option)
+	if err := WithTrigger(Trigger{Metric: MetricCPU, Threshold: 80})(option); err == nil {
+		t.Fatal("cpu trigger is accepted without the cpu metrics")
+	}
+	if err := WithTrigger(Trigger{Metric: MetricHeap, Threshold: 1})(option); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/collector/ingest/ingestpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const grpcTimeout = time.Second * 60
+
+func toPBMeta(m *ReceiveProfileReq) *ingestpb.Meta {
+	return &ingestpb.Meta{
+		Service:              m.Service,
+		ServiceVersion:       m.ServiceVersion,
+		Host:                 m.Host,
+		GoVersion:            m.GoVersion,
+		ProfileType:          m.ProfileType,
+		SendTime:             m.SendTime,
+		CreateTime:           m.CreateTime,
+		Delta:                m.Delta,
+		Tags:                 m.Tags,
+		Triggered:            m.Triggered,
+		TriggerReason:        m.TriggerReason,
+		BlockProfileRate:     int64(m.BlockProfileRate),
+		MutexProfileFraction: int64(m.MutexProfileFraction),
+		MemProfileRate:       int64(m.MemProfileRate),
+	}
+}
+
+// apiKeyCredentials sends the api key with every call.
+type apiKeyCredentials string
+
+func (k apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(k)}, nil
+}
+
+// RequireTransportSecurity allows the key to be sent over an insecure connection,
+// as the grpc.WithInsecure collectors used to be the only choice.
+func (k apiKeyCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// retryableStatus reports whether an upload that fails with err may succeed later,
+// the uploads cancelled by a stop are retried as well.
+func retryableStatus(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded, codes.Canceled:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendGRPC sends the uploads to the ProfileService of the collector,
+// a batch goes through one UploadStream call, a single upload through Upload.
+func (a *Agent) sendGRPC(ctx context.Context, uploads []*upload) ([]*upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, grpcTimeout)
+	defer cancel()
+	if a.o.BatchSize > 1 {
+		return a.sendStream(ctx, uploads)
+	}
+	var (
+		retry    []*upload
+		firstErr error
+	)
+	for _, u := range uploads {
+		_, err := a.pc.Upload(ctx, &ingestpb.UploadRequest{
+			Meta:    toPBMeta(&u.meta),
+			Profile: u.data,
+		})
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if retryableStatus(err) || ctx.Err() != nil {
+			retry = append(retry, u)
+		}
+	}
+	return retry, firstErr
+}
+
+// sendStream sends the uploads in one stream, the i-th response is
+// the result of the i-th upload.
+func (a *Agent) sendStream(ctx context.Context, uploads []*upload) ([]*upload, error) {
+	failed := func(err error) ([]*upload, error) {
+		if retryableStatus(err) || ctx.Err() != nil {
+			return uploads, err
+		}
+		return nil, err
+	}
+	stream, err := a.pc.UploadStream(ctx)
+	if err != nil {
+		return failed(err)
+	}
+	for _, u := range uploads {
+		err := stream.Send(&ingestpb.UploadRequest{
+			Meta:    toPBMeta(&u.meta),
+			Profile: u.data,
+		})
+		if err == io.EOF {
+			// The server ended the stream, the real error comes from Recv.
+			break
+		}
+		if err != nil {
+			return failed(err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return failed(err)
+	}
+
+	var (
+		retry    []*upload
+		firstErr error
+	)
+	for i := range uploads {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			err = errors.New("result count mismatch")
+		}
+		if err != nil {
+			// The ones without a result may not have been received.
+			r, err := failed(err)
+			if r != nil {
+				retry = append(retry, uploads[i:]...)
+			}
+			return retry, err
+		}
+		if resp.GetError() == "" {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("profile[%s] is not ok: %s", uploads[i].meta.ProfileType, resp.GetError())
+		}
+		if resp.GetRetryable() {
+			retry = append(retry, uploads[i])
+		}
+	}
+	return retry, firstErr
+}
@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xiaojiaoyu100/profiler/collector/ingest/ingestpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeProfileService fails the profiles of type "block",
+// the unary ones with Unavailable.
+type fakeProfileService struct {
+	ingestpb.UnimplementedProfileServiceServer
+	mu    sync.Mutex
+	types []string
+}
+
+func (s *fakeProfileService) Upload(ctx context.Context, req *ingestpb.UploadRequest) (*ingestpb.UploadResponse, error) {
+	if req.GetMeta().GetProfileType() == "block" {
+		return nil, status.Error(codes.Unavailable, "down")
+	}
+	s.mu.Lock()
+	s.types = append(s.types, req.GetMeta().GetProfileType())
+	s.mu.Unlock()
+	return &ingestpb.UploadResponse{ProfileId: "id"}, nil
+}
+
+func (s *fakeProfileService) UploadStream(stream ingestpb.ProfileService_UploadStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp := &ingestpb.UploadResponse{ProfileId: "id"}
+		if req.GetMeta().GetProfileType() == "block" {
+			resp = &ingestpb.UploadResponse{Error: "down", Retryable: true}
+		} else {
+			s.mu.Lock()
+			s.types = append(s.types, req.GetMeta().GetProfileType())
+			s.mu.Unlock()
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func TestSendGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeProfileService{}
+	srv := grpc.NewServer()
+	ingestpb.RegisterProfileServiceServer(srv, fake)
+	go func() { _ = srv.Serve(lis) }()
+	defer srv.Stop()
+
+	newUploads := func() []*upload {
+		var uploads []*upload
+		for _, typ := range []string{"heap", "block", "goroutine"} {
+			u := &upload{data: []byte("pprof")}
+			u.meta.Service = "svc"
+			u.meta.ProfileType = typ
+			uploads = append(uploads, u)
+		}
+		return uploads
+	}
+
+	for _, batchSize := range []int{0, 10} {
+		fake.types = nil
+		a, err := New(
+			WithGRPCTransport(lis.Addr().String(), grpc.WithInsecure()),
+			WithService("svc", "v1"),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.o.BatchSize = batchSize
+
+		retry, err := a.send(context.Background(), newUploads())
+		if err == nil {
+			t.Errorf("batch size %d: no error", batchSize)
+		}
+		if len(retry) != 1 || retry[0].meta.ProfileType != "block" {
+			t.Errorf("batch size %d: retry = %v", batchSize, retry)
+		}
+		if len(fake.types) != 2 || fake.types[0] != "heap" || fake.types[1] != "goroutine" {
+			t.Errorf("batch size %d: received = %v", batchSize, fake.types)
+		}
+		_ = a.conn.Close()
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	var header string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	a, err := New(WithCollectorAddr(srv.URL), WithService("svc", "v1"), WithAPIKey("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.sendOne(context.Background(), &upload{data: []byte("pprof")}); err != nil {
+		t.Fatal(err)
+	}
+	if header != "Bearer k1" {
+		t.Errorf("http authorization = %q", header)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		header = strings.Join(md.Get("authorization"), ",")
+		return handler(ctx, req)
+	}))
+	ingestpb.RegisterProfileServiceServer(s, &fakeProfileService{})
+	go func() { _ = s.Serve(lis) }()
+	defer s.Stop()
+
+	a, err = New(WithGRPCTransport(lis.Addr().String(), grpc.WithInsecure()), WithService("svc", "v1"), WithAPIKey("k2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.conn.Close()
+	if _, err := a.send(context.Background(), []*upload{{data: []byte("pprof")}}); err != nil {
+		t.Fatal(err)
+	}
+	if header != "Bearer k2" {
+		t.Errorf("grpc authorization = %q", header)
+	}
+}
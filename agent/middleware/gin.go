@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"context"
+	"runtime/pprof"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Gin is the gin middleware, the route is the route template like /user/:id,
+// requests matching no route are labeled with the url path capped as usual.
+func (l *Labeler) Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		labels := l.labels(route, c.Request.Method, func(name string) []string {
+			return c.Request.Header.Values(name)
+		})
+		pprof.Do(c.Request.Context(), labels, func(ctx context.Context) {
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"context"
+	"runtime/pprof"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func metadataValues(ctx context.Context) func(name string) []string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return func(name string) []string {
+		return md.Get(strings.ToLower(name))
+	}
+}
+
+// UnaryServerInterceptor labels a unary call by its full method name.
+func (l *Labeler) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		labels := l.labels(info.FullMethod, "", metadataValues(ctx))
+		pprof.Do(ctx, labels, func(ctx context.Context) {
+			resp, err = handler(ctx, req)
+		})
+		return resp, err
+	}
+}
+
+type labeledStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *labeledStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor labels a stream by its full method name.
+func (l *Labeler) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		labels := l.labels(info.FullMethod, "", metadataValues(ss.Context()))
+		pprof.Do(ss.Context(), labels, func(ctx context.Context) {
+			err = handler(srv, &labeledStream{ServerStream: ss, ctx: ctx})
+		})
+		return err
+	}
+}
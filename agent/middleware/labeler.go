@@ -0,0 +1,137 @@
+// Package middleware wraps every request in pprof.Do, so the samples of
+// the cpu profile uploaded by the agent can be filtered and grouped by request.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/pprof"
+	"sync"
+)
+
+const (
+	LabelRoute  = "route"
+	LabelMethod = "method"
+
+	// OtherValue replaces the values of a label beyond the cardinality cap.
+	OtherValue = "other"
+)
+
+const defaultMaxCardinality = 100
+
+type Option struct {
+	Headers        map[string]string // header(gRPC metadata) -> label key
+	MaxCardinality int               // 每个label最多的不同取值，超出的取值记为 other
+	RouteFunc      func(r *http.Request) string
+}
+
+type Setter func(o *Option) error
+
+// WithHeader labels the requests by the value of header, gRPC metadata
+// of the same name is used by the interceptors.
+func WithHeader(header string, labelKey string) Setter {
+	return func(o *Option) error {
+		if header == "" || labelKey == "" {
+			return errors.New("empty header or label key")
+		}
+		o.Headers[header] = labelKey
+		return nil
+	}
+}
+
+func WithMaxCardinality(n int) Setter {
+	return func(o *Option) error {
+		if n <= 0 {
+			return errors.New("max cardinality must be positive")
+		}
+		o.MaxCardinality = n
+		return nil
+	}
+}
+
+// WithRouteFunc sets how the net/http middleware gets the route of a request,
+// it is the url path by default.
+func WithRouteFunc(f func(r *http.Request) string) Setter {
+	return func(o *Option) error {
+		o.RouteFunc = f
+		return nil
+	}
+}
+
+// Labeler builds the pprof labels of the requests.
+type Labeler struct {
+	o      *Option
+	mu     sync.Mutex
+	values map[string]map[string]struct{}
+}
+
+func New(ff ...Setter) (*Labeler, error) {
+	option := &Option{
+		Headers:        make(map[string]string),
+		MaxCardinality: defaultMaxCardinality,
+		RouteFunc: func(r *http.Request) string {
+			return r.URL.Path
+		},
+	}
+	for _, f := range ff {
+		if err := f(option); err != nil {
+			return nil, err
+		}
+	}
+	return &Labeler{
+		o:      option,
+		values: make(map[string]map[string]struct{}),
+	}, nil
+}
+
+// value caps the distinct values of every label key, so a route with ids in
+// its path can not make the profiles huge.
+func (l *Labeler) value(key, v string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seen, ok := l.values[key]
+	if !ok {
+		seen = make(map[string]struct{})
+		l.values[key] = seen
+	}
+	if _, ok := seen[v]; ok {
+		return v
+	}
+	if len(seen) >= l.o.MaxCardinality {
+		return OtherValue
+	}
+	seen[v] = struct{}{}
+	return v
+}
+
+// labels returns the label set of a request, header returns the values of a header.
+func (l *Labeler) labels(route, method string, header func(name string) []string) pprof.LabelSet {
+	var args []string
+	if route != "" {
+		args = append(args, LabelRoute, l.value(LabelRoute, route))
+	}
+	if method != "" {
+		args = append(args, LabelMethod, l.value(LabelMethod, method))
+	}
+	for name, key := range l.o.Headers {
+		values := header(name)
+		if len(values) == 0 || values[0] == "" {
+			continue
+		}
+		args = append(args, key, l.value(key, values[0]))
+	}
+	return pprof.Labels(args...)
+}
+
+// HTTP is the net/http middleware.
+func (l *Labeler) HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		labels := l.labels(l.o.RouteFunc(r), r.Method, func(name string) []string {
+			return r.Header.Values(name)
+		})
+		pprof.Do(r.Context(), labels, func(ctx context.Context) {
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	l, err := New(WithHeader("X-Tenant", "tenant"), WithMaxCardinality(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routes []string
+	h := l.HTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := pprof.Label(r.Context(), LabelRoute)
+		routes = append(routes, route)
+		if tenant, _ := pprof.Label(r.Context(), "tenant"); tenant != "t1" {
+			t.Errorf("tenant = %q, want t1", tenant)
+		}
+	}))
+	for _, p := range []string{"/a", "/b", "/c", "/a"} {
+		r := httptest.NewRequest(http.MethodGet, p, nil)
+		r.Header.Set("X-Tenant", "t1")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	want := []string{"/a", "/b", OtherValue, "/a"}
+	for i := range want {
+		if routes[i] != want[i] {
+			t.Fatalf("routes = %v, want %v", routes, want)
+		}
+	}
+}
@@ -1,12 +1,19 @@
 package agent
 
-import "time"
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/profile"
+	"google.golang.org/grpc"
+)
 
 type Option struct {
 	CollectorAddr         string
 	Service               string
 	ServiceVersion        string
 	goVersion             string
+	Tags                  map[string]string
 	BreakPeriod           time.Duration
 	CPUProfiling          bool `profile:"cpu"`
 	CPUProfilingPeriod    time.Duration
@@ -16,9 +23,29 @@ type Option struct {
 	MutexProfiling        bool `profile:"mutex"`
 	GoroutineProfiling    bool `profile:"goroutine"`
 	ThreadCreateProfiling bool `profile:"threadcreate"`
+	DeltaProfiling        bool
+	BlockProfileRate      int // 开启block profiling时传给runtime.SetBlockProfileRate
+	MutexProfileFraction  int // 开启mutex profiling时传给runtime.SetMutexProfileFraction
+	MemProfileRate        int // 大于0时设置runtime.MemProfileRate
+	Schedules             map[profile.Type]Schedule
+	Triggers              []Trigger
+	TriggerInterval       time.Duration // 检查触发条件的间隔
+	SpoolDir              string        // 发送失败的profile保存的目录，为空时保存在内存中
+	SpoolMaxBytes         int64
+	SpoolMaxAge           time.Duration
+	BatchSize             int // 大于1时通过/v2/profile批量上传
+	BatchWait             time.Duration
+	GRPCTarget            string // 不为空时通过gRPC上传，代替CollectorAddr
+	GRPCDialOptions       []grpc.DialOption
+	APIKey                string // 上传时通过Authorization: Bearer传给collector
+	TLSConfig             *tls.Config
 }
 
 const (
 	defaultBreakPeriod        = time.Second * 30
 	defaultCPUProfilingPeriod = time.Second * 10
+	defaultJitter             = time.Second * 5
+
+	defaultBlockProfileRate     = 10000 // 平均每阻塞10µs采样一次
+	defaultMutexProfileFraction = 10
 )
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/cep21/circuit/v3"
+	"github.com/cep21/circuit/v3/closers/hystrix"
+	"github.com/xiaojiaoyu100/cast"
+)
+
+// The upload retry and backoff, the same for cast and retryClient.
+const (
+	uploadTimeout     = time.Second * 60
+	uploadRetry       = 2
+	uploadBackoffBase = time.Millisecond * 200
+	uploadBackoffCap  = time.Millisecond * 500
+)
+
+// agentCircuitConfig is the circuit of the uploads, for cast and retryClient alike.
+func agentCircuitConfig() circuit.Config {
+	configuration := hystrix.Factory{
+		ConfigureOpener: hystrix.ConfigureOpener{
+			ErrorThresholdPercentage: 70,
+			RequestVolumeThreshold:   10,
+			RollingDuration:          10 * time.Second,
+			Now:                      time.Now,
+			NumBuckets:               10,
+		},
+		ConfigureCloser: hystrix.ConfigureCloser{
+			SleepWindow:                  10 * time.Second,
+			HalfOpenAttempts:             1,
+			RequiredConcurrentSuccessful: 1,
+		},
+	}
+	config := configuration.Configure(agentCircuit)
+	config.Execution = circuit.ExecutionConfig{
+		Timeout:               10 * time.Second,
+		MaxConcurrentRequests: 1000,
+	}
+	config.Fallback = circuit.FallbackConfig{
+		MaxConcurrentRequests: 1000,
+	}
+	return config
+}
+
+// retryClient sends the requests over tls the way cast sends the others, which
+// can not be configured with tls: through the agent circuit, retrying the network
+// errors after a decorrelated jitter backoff.
+type retryClient struct {
+	hc *http.Client
+	cb *circuit.Circuit
+}
+
+func newRetryClient(hc *http.Client) *retryClient {
+	return &retryClient{
+		hc: hc,
+		cb: circuit.NewCircuitFromConfig(agentCircuit, agentCircuitConfig()),
+	}
+}
+
+// backoff is the decorrelated jitter backoff after prev, the previous one:
+// random between the base and three times prev, up to the cap.
+func backoff(prev time.Duration) time.Duration {
+	if prev < uploadBackoffBase {
+		prev = uploadBackoffBase
+	}
+	d := uploadBackoffBase + time.Duration(rand.Int63n(int64(3*prev-uploadBackoffBase)))
+	if d > uploadBackoffCap {
+		d = uploadBackoffCap
+	}
+	return d
+}
+
+// do sends the request made by newReq, it is made again for every retry.
+func (rc *retryClient) do(ctx context.Context, newReq func() (*http.Request, error)) (*http.Response, error) {
+	var sleep time.Duration
+	for count := 0; ; count++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		var (
+			resp     *http.Response
+			fallback bool
+		)
+		err = rc.cb.Execute(ctx, func(context.Context) error {
+			var err error
+			resp, err = rc.hc.Do(req)
+			if err != nil {
+				fallback = true
+			}
+			return err
+		}, func(_ context.Context, err error) error {
+			return err
+		})
+		if err == nil {
+			return resp, nil
+		}
+		if (fallback && rc.cb.IsOpen()) || count >= uploadRetry || !cast.ShouldRetry(err) {
+			return nil, err
+		}
+		sleep = backoff(sleep)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(sleep):
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestUploadOverTLS checks the uploads over https retry and fail like the ones over http.
+func TestUploadOverTLS(t *testing.T) {
+	for _, secure := range []bool{false, true} {
+		var (
+			mu       sync.Mutex
+			requests int
+			code     int
+			hangUp   bool
+		)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests++
+			if hangUp {
+				// A network error, it is retried.
+				hangUp = false
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				conn.Close()
+				return
+			}
+			w.WriteHeader(code)
+		})
+		var srv *httptest.Server
+		setters := []Setter{WithService("svc", "v1")}
+		if secure {
+			srv = httptest.NewTLSServer(handler)
+			setters = append(setters, func(o *Option) error {
+				o.TLSConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+				return nil
+			})
+		} else {
+			srv = httptest.NewServer(handler)
+		}
+		a, err := New(append(setters, WithCollectorAddr(srv.URL))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secure != (a.rc != nil) {
+			t.Fatalf("secure %v: unexpected client", secure)
+		}
+		u := &upload{meta: ReceiveProfileReq{}, data: []byte("profile")}
+
+		code = http.StatusServiceUnavailable
+		retryable, err := a.sendOne(context.Background(), u)
+		if err == nil || !retryable || requests != 1 {
+			t.Fatalf("secure %v: 503 gives retryable %v, err %v after %d requests", secure, retryable, err, requests)
+		}
+
+		code, hangUp, requests = http.StatusOK, true, 0
+		if _, err := a.sendOne(context.Background(), u); err != nil || requests != 2 {
+			t.Fatalf("secure %v: hang up gives err %v after %d requests", secure, err, requests)
+		}
+		srv.Close()
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var sleep time.Duration
+	for i := 0; i < 100; i++ {
+		prev := sleep
+		sleep = backoff(prev)
+		if sleep < uploadBackoffBase || sleep > uploadBackoffCap {
+			t.Fatalf("backoff %v after %v is out of [%v, %v]", sleep, prev, uploadBackoffBase, uploadBackoffCap)
+		}
+		if prev > 0 && sleep > 3*prev {
+			t.Fatalf("backoff %v after %v grows beyond three times", sleep, prev)
+		}
+	}
+}
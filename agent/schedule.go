@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/profile"
+	"go.uber.org/zap"
+)
+
+// Schedule is how a profile type is collected, every type has its own.
+type Schedule struct {
+	Interval time.Duration // 两次采集开始的间隔
+	Duration time.Duration // 每次采集的时长，只对cpu有效
+	Jitter   time.Duration // 每次等待随机增加[0, Jitter)，避免所有实例同时采集
+}
+
+// wait returns how long to wait before the next collection.
+func (s Schedule) wait(interval time.Duration) time.Duration {
+	if s.Jitter > 0 {
+		interval += time.Duration(rand.Int63n(int64(s.Jitter)))
+	}
+	return interval
+}
+
+// profileTypes returns the enabled profile types.
+func (o *Option) profileTypes() []profile.Type {
+	var ret []profile.Type
+	t := reflect.TypeOf(*o)
+	v := reflect.ValueOf(o).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("profile")
+		if tag == "" {
+			continue
+		}
+		if v.Field(i).Type().Kind() != reflect.Bool {
+			continue
+		}
+		if !v.Field(i).Bool() {
+			continue
+		}
+		ret = append(ret, profile.ParseType(tag))
+	}
+	return ret
+}
+
+// initSchedules fills the schedule of every enabled profile type.
+func (o *Option) initSchedules() {
+	schedules := make(map[profile.Type]Schedule)
+	for _, t := range o.profileTypes() {
+		s, ok := o.Schedules[t]
+		if !ok {
+			s.Jitter = defaultJitter
+		}
+		if s.Interval == 0 {
+			s.Interval = o.BreakPeriod
+		}
+		if t == profile.TypeCPU && s.Duration == 0 {
+			s.Duration = o.CPUProfilingPeriod
+		}
+		schedules[t] = s
+	}
+	o.Schedules = schedules
+}
+
+// onSchedule runs the schedules of all profile types, the triggers, the batching
+// and the resending of the spool concurrently until Stop.
+func (a *Agent) onSchedule(ctx context.Context) {
+	defer close(a.done)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-a.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for profileType, schedule := range a.o.Schedules {
+		wg.Add(1)
+		go func(profileType profile.Type, schedule Schedule) {
+			defer wg.Done()
+			a.runSchedule(ctx, profileType, schedule)
+		}(profileType, schedule)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.onRetry(ctx)
+	}()
+	if a.o.BatchSize > 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.onBatch(ctx)
+		}()
+	}
+	if len(a.o.Triggers) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.onTrigger(ctx)
+		}()
+	}
+	wg.Wait()
+
+	// Send what is left in the batch, as nothing is collected any more.
+	a.flushBatch()
+}
+
+func (a *Agent) runSchedule(ctx context.Context, profileType profile.Type, schedule Schedule) {
+	ti := time.NewTimer(schedule.wait(0))
+	defer ti.Stop()
+	var buf bytes.Buffer
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ti.C:
+			start := time.Now()
+			if err := a.collectAndSend(ctx, &buf, profileType, ""); err != nil {
+				a.logger.Warn(fmt.Sprintf("fail to collect and send: %v", profileType), zap.Error(err))
+			}
+			buf.Reset()
+			interval := schedule.Interval - time.Since(start)
+			if interval < 0 {
+				interval = 0
+			}
+			ti.Reset(schedule.wait(interval))
+		}
+	}
+}
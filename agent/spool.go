@@ -0,0 +1,191 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolMaxBytes = 32 << 20
+	defaultSpoolMaxAge   = time.Hour * 24
+
+	minRetryBackoff = time.Second * 5
+	maxRetryBackoff = time.Minute * 5
+
+	spoolExt = ".spool"
+)
+
+type spoolEntry struct {
+	name    string
+	created time.Time
+	size    int64
+	data    []byte // 只有内存中的spool才有
+}
+
+// spool keeps the uploads that fail to reach the collector until they are resent,
+// it keeps them in dir so they survive restarts, or in memory if dir is empty.
+type spool struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	entries  []*spoolEntry // 从旧到新
+	size     int64
+	seq      uint64
+}
+
+func newSpool(dir string, maxBytes int64, maxAge time.Duration) (*spool, error) {
+	s := &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("fail to create spool dir: %w", err)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read spool dir: %w", err)
+	}
+	for _, info := range infos {
+		if info.IsDir() || !strings.HasSuffix(info.Name(), spoolExt) {
+			continue
+		}
+		created, ok := parseSpoolName(info.Name())
+		if !ok {
+			continue
+		}
+		s.entries = append(s.entries, &spoolEntry{
+			name:    info.Name(),
+			created: created,
+			size:    info.Size(),
+		})
+		s.size += info.Size()
+	}
+	sort.Slice(s.entries, func(i, j int) bool {
+		return s.entries[i].name < s.entries[j].name
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trim(time.Now())
+	return s, nil
+}
+
+// spoolName sorts in the order the entries are pushed.
+func spoolName(created time.Time, seq uint64) string {
+	return fmt.Sprintf("%020d-%010d%s", created.UnixNano(), seq, spoolExt)
+}
+
+func parseSpoolName(name string) (time.Time, bool) {
+	i := strings.Index(name, "-")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(name[:i], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// push adds an upload, the oldest ones are dropped beyond the max bytes.
+func (s *spool) push(b []byte) error {
+	size := int64(len(b))
+	if size > s.maxBytes {
+		return errors.New("upload is larger than the spool")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.seq++
+	e := &spoolEntry{
+		name:    spoolName(now, s.seq),
+		created: now,
+		size:    size,
+	}
+	if s.dir == "" {
+		e.data = b
+	} else {
+		tmp := filepath.Join(s.dir, e.name+".tmp")
+		if err := ioutil.WriteFile(tmp, b, 0o644); err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("fail to write spool: %w", err)
+		}
+		if err := os.Rename(tmp, filepath.Join(s.dir, e.name)); err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("fail to write spool: %w", err)
+		}
+	}
+	s.entries = append(s.entries, e)
+	s.size += size
+	s.trim(now)
+	return nil
+}
+
+// peek returns the oldest upload, the entry is nil if the spool is empty.
+func (s *spool) peek() (*spoolEntry, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trim(time.Now())
+	if len(s.entries) == 0 {
+		return nil, nil, nil
+	}
+	e := s.entries[0]
+	if s.dir == "" {
+		return e, e.data, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, e.name))
+	if err != nil {
+		return e, nil, fmt.Errorf("fail to read spool: %w", err)
+	}
+	return e, b, nil
+}
+
+func (s *spool) remove(e *spoolEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.entries {
+		if v == e {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			s.drop(e)
+			return
+		}
+	}
+}
+
+func (s *spool) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// trim drops the expired entries and the oldest ones beyond the max bytes.
+func (s *spool) trim(now time.Time) {
+	for len(s.entries) > 0 {
+		e := s.entries[0]
+		if s.size <= s.maxBytes && now.Sub(e.created) <= s.maxAge {
+			return
+		}
+		s.entries = s.entries[1:]
+		s.drop(e)
+	}
+}
+
+func (s *spool) drop(e *spoolEntry) {
+	s.size -= e.size
+	if s.dir != "" {
+		_ = os.Remove(filepath.Join(s.dir, e.name))
+	}
+}
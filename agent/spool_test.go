@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/profile"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := newSpool(dir, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []string{"aaaa", "bbbb", "cccc"} {
+		if err := s.push([]byte(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.push(make([]byte, 11)); err == nil {
+		t.Fatal("upload larger than the spool is pushed")
+	}
+
+	// The spool survives restarts, and the oldest is dropped beyond the max bytes.
+	s, err = newSpool(dir, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.len() != 2 {
+		t.Fatalf("spool len = %d, want 2", s.len())
+	}
+	e, b, err := s.peek()
+	if err != nil || string(b) != "bbbb" {
+		t.Fatalf("peek = %q, %v", b, err)
+	}
+	s.remove(e)
+	if _, b, _ = s.peek(); string(b) != "cccc" {
+		t.Fatalf("peek = %q, want cccc", b)
+	}
+
+	s, err = newSpool("", 10, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.push([]byte("aaaa")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if e, _, _ := s.peek(); e != nil {
+		t.Fatal("expired upload is not dropped")
+	}
+}
+
+func TestResend(t *testing.T) {
+	var down = true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	a, err := New(WithCollectorAddr(srv.URL), WithService("svc", "v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []string{`{"profile_type":"heap"}`, `{"profile_type":"cpu"}`} {
+		if err := a.spool.push([]byte(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	if backoff := a.resend(ctx, minRetryBackoff); backoff != 2*minRetryBackoff || a.spool.len() != 2 {
+		t.Fatalf("backoff = %v, spool len = %d", backoff, a.spool.len())
+	}
+	down = false
+	if backoff := a.resend(ctx, 2*minRetryBackoff); backoff != minRetryBackoff || a.spool.len() != 0 {
+		t.Fatalf("backoff = %v, spool len = %d", backoff, a.spool.len())
+	}
+}
+
+func TestDeliverCancelled(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	for _, batchSize := range []int{1, 2} {
+		a, err := New(WithCollectorAddr(srv.URL), WithService("svc", "v1"), WithBatch(batchSize, time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A stop cancels the uploads in flight, they are spooled instead of dropped.
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err = a.deliver(ctx, []*upload{{meta: ReceiveProfileReq{ProfileType: "heap"}, data: []byte("heap")}})
+		cancel()
+		if err == nil || a.spool.len() != 1 {
+			t.Fatalf("batch size %d: err = %v, spool len = %d", batchSize, err, a.spool.len())
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		metas []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/profile" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		metas = append(metas, r.MultipartForm.Value["meta"]...)
+		mu.Unlock()
+		detail := receiveProfileBatchDetail{}
+		for range r.MultipartForm.File["profile"] {
+			detail.ResultList = append(detail.ResultList, batchResult{Code: http.StatusOK})
+		}
+		_ = json.NewEncoder(w).Encode(&detail)
+	}))
+	defer srv.Close()
+
+	a, err := New(
+		WithCollectorAddr(srv.URL),
+		WithService("svc", "v1"),
+		WithCPUProfiling(false, 0),
+		WithAllocsProfiling(false),
+		WithGoroutineProfiling(true),
+		WithSchedule(profile.TypeHeap, Schedule{Interval: 50 * time.Millisecond}),
+		WithSchedule(profile.TypeGoroutine, Schedule{Interval: 50 * time.Millisecond}),
+		WithBatch(100, time.Hour),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start(context.Background())
+	time.Sleep(200 * time.Millisecond)
+	a.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Nothing is sent before the stop, as the batch is never full.
+	if len(metas) < 6 {
+		t.Fatalf("%d profiles are sent, want at least 6", len(metas))
+	}
+}
+
+func TestDecodeUpload(t *testing.T) {
+	u := &upload{data: []byte("pprof\ndata")}
+	u.meta.ProfileType = "heap"
+	b, err := encodeUpload(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeUpload(b)
+	if err != nil || got.meta.ProfileType != "heap" || string(got.data) != "pprof\ndata" {
+		t.Fatalf("decode = %+v, %v", got, err)
+	}
+
+	// The /v1/profile body spooled by older agents.
+	got, err = decodeUpload([]byte(`{"profile_type":"cpu","profile":"cHByb2Y="}`))
+	if err != nil || got.meta.ProfileType != "cpu" || string(got.data) != "pprof" {
+		t.Fatalf("decode = %+v, %v", got, err)
+	}
+}
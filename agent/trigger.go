@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/profile"
+	"go.uber.org/zap"
+)
+
+// The metrics a Trigger watches.
+const (
+	// MetricCPU is the cpu time spent running Go code in percent of the cpu time
+	// GOMAXPROCS allows, it needs Go 1.20 or later. The runtime updates the cpu time
+	// at the end of every GC cycle, so it is the average between the last cycles.
+	MetricCPU = "cpu"
+	// MetricHeap is the bytes of the heap objects.
+	MetricHeap = "heap"
+	// MetricGoroutine is the number of goroutines.
+	MetricGoroutine = "goroutine"
+)
+
+const (
+	defaultTriggerInterval = time.Second * 5
+	defaultTriggerCooldown = time.Minute * 5
+)
+
+// Trigger captures profiles as soon as a metric goes beyond a threshold
+// or grows too fast, its uploads are flagged as triggered.
+type Trigger struct {
+	Metric          string
+	Threshold       float64        // 指标达到该值时触发，0表示不检查
+	IncreasePercent float64        // 指标相比上次检查增长的百分比达到该值时触发，0表示不检查
+	ProfileTypes    []profile.Type // 触发时采集的profile，默认与指标同名
+	Cooldown        time.Duration  // 两次触发的最小间隔，默认5分钟
+}
+
+func (t *Trigger) init() error {
+	switch t.Metric {
+	case MetricCPU:
+		if len(t.ProfileTypes) == 0 {
+			t.ProfileTypes = []profile.Type{profile.TypeCPU}
+		}
+	case MetricHeap:
+		if len(t.ProfileTypes) == 0 {
+			t.ProfileTypes = []profile.Type{profile.TypeHeap}
+		}
+	case MetricGoroutine:
+		if len(t.ProfileTypes) == 0 {
+			t.ProfileTypes = []profile.Type{profile.TypeGoroutine}
+		}
+	default:
+		return fmt.Errorf("unknown trigger metric: %s", t.Metric)
+	}
+	if t.Threshold <= 0 && t.IncreasePercent <= 0 {
+		return errors.New("trigger has neither threshold nor increase percent")
+	}
+	if err := checkMetric(t.Metric); err != nil {
+		return err
+	}
+	for _, pt := range t.ProfileTypes {
+		if pt <= profile.TypeUnknown || pt > profile.TypeThreadCreate {
+			return fmt.Errorf("unknown trigger profile type: %v", pt)
+		}
+	}
+	if t.Cooldown <= 0 {
+		t.Cooldown = defaultTriggerCooldown
+	}
+	return nil
+}
+
+// reason returns why the trigger fires, or an empty string if it does not.
+func (t *Trigger) reason(cur float64, prev float64, hasPrev bool) string {
+	if t.Threshold > 0 && cur >= t.Threshold {
+		return fmt.Sprintf("%s %.0f >= %.0f", t.Metric, cur, t.Threshold)
+	}
+	if t.IncreasePercent > 0 && hasPrev && prev > 0 {
+		increase := (cur - prev) * 100 / prev
+		if increase >= t.IncreasePercent {
+			return fmt.Sprintf("%s increased %.0f%% from %.0f to %.0f", t.Metric, increase, prev, cur)
+		}
+	}
+	return ""
+}
+
+// WithTrigger adds a trigger, the metrics are checked every TriggerInterval.
+func WithTrigger(t Trigger) Setter {
+	return func(o *Option) error {
+		if err := t.init(); err != nil {
+			return err
+		}
+		o.Triggers = append(o.Triggers, t)
+		return nil
+	}
+}
+
+func WithTriggerInterval(d time.Duration) Setter {
+	return func(o *Option) error {
+		if d <= 0 {
+			return errors.New("trigger interval must be positive")
+		}
+		o.TriggerInterval = d
+		return nil
+	}
+}
+
+// The runtime metrics the trigger metrics are computed from.
+const (
+	sampleGoroutines  = "/sched/goroutines:goroutines"
+	sampleHeapObjects = "/memory/classes/heap/objects:bytes"
+	sampleCPUUser     = "/cpu/classes/user:cpu-seconds"
+	sampleCPUTotal    = "/cpu/classes/total:cpu-seconds"
+)
+
+var metricSamples = map[string][]string{
+	MetricCPU:       {sampleCPUUser, sampleCPUTotal},
+	MetricHeap:      {sampleHeapObjects},
+	MetricGoroutine: {sampleGoroutines},
+}
+
+// runtimeMetrics describes the metrics the runtime supports.
+var runtimeMetrics = metrics.All
+
+// checkMetric fails if the runtime does not support the samples of metric,
+// metrics.Read would silently skip them and the trigger would never fire.
+func checkMetric(metric string) error {
+	supported := make(map[string]bool)
+	for _, d := range runtimeMetrics() {
+		supported[d.Name] = true
+	}
+	for _, name := range metricSamples[metric] {
+		if !supported[name] {
+			return fmt.Errorf("trigger metric %s needs %s, which %s does not support", metric, name, runtime.Version())
+		}
+	}
+	return nil
+}
+
+// metricReader reads the metrics the triggers watch,
+// the cpu usage is the rate between two reads.
+type metricReader struct {
+	samples   []metrics.Sample
+	prevUser  float64
+	prevTotal float64
+	hasPrev   bool
+}
+
+func newMetricReader(triggers []Trigger) *metricReader {
+	r := new(metricReader)
+	seen := make(map[string]bool)
+	for _, t := range triggers {
+		for _, name := range metricSamples[t.Metric] {
+			if !seen[name] {
+				seen[name] = true
+				r.samples = append(r.samples, metrics.Sample{Name: name})
+			}
+		}
+	}
+	return r
+}
+
+func (r *metricReader) read() map[string]float64 {
+	metrics.Read(r.samples)
+	values := make(map[string]float64, len(r.samples))
+	for _, s := range r.samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			values[s.Name] = float64(s.Value.Uint64())
+		case metrics.KindFloat64:
+			values[s.Name] = s.Value.Float64()
+		}
+	}
+	return r.compute(values)
+}
+
+// compute turns the runtime metrics into the trigger metrics,
+// the cpu usage is missing until the cpu time has changed since the first read.
+func (r *metricReader) compute(values map[string]float64) map[string]float64 {
+	ret := make(map[string]float64)
+	if v, ok := values[sampleGoroutines]; ok {
+		ret[MetricGoroutine] = v
+	}
+	if v, ok := values[sampleHeapObjects]; ok {
+		ret[MetricHeap] = v
+	}
+	user, ok1 := values[sampleCPUUser]
+	total, ok2 := values[sampleCPUTotal]
+	if ok1 && ok2 {
+		if r.hasPrev && total > r.prevTotal {
+			ret[MetricCPU] = (user - r.prevUser) * 100 / (total - r.prevTotal)
+		}
+		r.prevUser, r.prevTotal, r.hasPrev = user, total, true
+	}
+	return ret
+}
+
+// onTrigger checks the metrics periodically and captures the profiles
+// of the triggers that fire.
+func (a *Agent) onTrigger(ctx context.Context) {
+	var (
+		wg        sync.WaitGroup
+		prev      map[string]float64
+		firedMu   sync.Mutex
+		lastFired = make([]time.Time, len(a.o.Triggers))
+		reader    = newMetricReader(a.o.Triggers)
+	)
+	defer wg.Wait()
+
+	ti := time.NewTicker(a.o.TriggerInterval)
+	defer ti.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ti.C:
+			cur := reader.read()
+			for i := range a.o.Triggers {
+				t := &a.o.Triggers[i]
+				v, ok := cur[t.Metric]
+				if !ok {
+					continue
+				}
+				firedMu.Lock()
+				cooling := !lastFired[i].IsZero() && now.Sub(lastFired[i]) < t.Cooldown
+				firedMu.Unlock()
+				if cooling {
+					continue
+				}
+				p, hasPrev := prev[t.Metric]
+				reason := t.reason(v, p, hasPrev)
+				if reason == "" {
+					continue
+				}
+				// The cooldown starts now, so the trigger does not fire again while
+				// collecting, and it is reset if no profile is collected.
+				firedMu.Lock()
+				lastFired[i] = now
+				firedMu.Unlock()
+				a.logger.Info("profiling is triggered", zap.String("reason", reason))
+				wg.Add(1)
+				go func(i int, now time.Time) {
+					defer wg.Done()
+					if a.fire(ctx, t, reason) {
+						return
+					}
+					firedMu.Lock()
+					if lastFired[i].Equal(now) {
+						lastFired[i] = time.Time{}
+					}
+					firedMu.Unlock()
+				}(i, now)
+			}
+			prev = cur
+		}
+	}
+}
+
+// fire collects and sends the profiles of a trigger concurrently,
+// it reports whether any of them is collected.
+func (a *Agent) fire(ctx context.Context, t *Trigger, reason string) bool {
+	var (
+		wg        sync.WaitGroup
+		collected int32
+	)
+	for _, pt := range t.ProfileTypes {
+		wg.Add(1)
+		go func(pt profile.Type) {
+			defer wg.Done()
+			err := a.collectAndSend(ctx, new(bytes.Buffer), pt, reason)
+			if !errors.Is(err, errNotCollected) {
+				atomic.StoreInt32(&collected, 1)
+			}
+			if err != nil {
+				a.logger.Warn(fmt.Sprintf("fail to collect and send: %v", pt), zap.Error(err))
+			}
+		}(pt)
+	}
+	wg.Wait()
+	return atomic.LoadInt32(&collected) == 1
+}
@@ -0,0 +1,336 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const batchFlushTimeout = time.Second * 10
+
+// upload is a collected profile waiting to be sent,
+// the Profile field of meta is left empty.
+type upload struct {
+	meta ReceiveProfileReq
+	data []byte
+}
+
+// encodeUpload encodes an upload for the spool: the json meta, a newline,
+// then the raw profile.
+func encodeUpload(u *upload) ([]byte, error) {
+	b, err := json.Marshal(&u.meta)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, '\n')
+	return append(b, u.data...), nil
+}
+
+// decodeUpload decodes an upload from the spool, it also accepts
+// a /v1/profile request body which older agents spool.
+func decodeUpload(b []byte) (*upload, error) {
+	u := new(upload)
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		if err := json.Unmarshal(b, &u.meta); err != nil {
+			return nil, err
+		}
+		data, err := base64.StdEncoding.DecodeString(u.meta.Profile)
+		if err != nil {
+			return nil, err
+		}
+		u.meta.Profile = ""
+		u.data = data
+		return u, nil
+	}
+	if err := json.Unmarshal(b[:i], &u.meta); err != nil {
+		return nil, err
+	}
+	u.data = b[i+1:]
+	return u, nil
+}
+
+func retryableCode(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
+
+// deliver sends the uploads and spools the ones that may succeed later.
+func (a *Agent) deliver(ctx context.Context, uploads []*upload) error {
+	retry, err := a.send(ctx, uploads)
+	for _, u := range retry {
+		b, eerr := encodeUpload(u)
+		if eerr == nil {
+			eerr = a.spool.push(b)
+		}
+		if eerr != nil {
+			a.logger.Warn(fmt.Sprintf("fail to spool profile[%s]", u.meta.ProfileType), zap.Error(eerr))
+		}
+	}
+	return err
+}
+
+// send sends the uploads, it returns the ones that fail but may succeed later,
+// the others that fail are dropped.
+func (a *Agent) send(ctx context.Context, uploads []*upload) ([]*upload, error) {
+	if a.pc != nil {
+		return a.sendGRPC(ctx, uploads)
+	}
+	if a.o.BatchSize > 1 {
+		return a.sendBatch(ctx, uploads)
+	}
+	var (
+		retry    []*upload
+		firstErr error
+	)
+	for _, u := range uploads {
+		retryable, err := a.sendOne(ctx, u)
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if retryable {
+			retry = append(retry, u)
+		}
+	}
+	return retry, firstErr
+}
+
+// post posts body to path of the collector, it returns the status code and the response body.
+func (a *Agent) post(ctx context.Context, path string, contentType string, body []byte) (int, []byte, error) {
+	if a.rc == nil {
+		req := a.c.NewRequest().Post().WithPath(path).WithCustomBody(contentType, body)
+		resp, err := a.c.Do(ctx, req)
+		if err != nil {
+			return 0, nil, err
+		}
+		return resp.StatusCode(), resp.Body(), nil
+	}
+	resp, err := a.rc.do(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(a.o.CollectorAddr, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		if a.o.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+a.o.APIKey)
+		}
+		return req, nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, b, nil
+}
+
+// sendOne sends an upload to /v1/profile,
+// retryable reports whether it may succeed later.
+func (a *Agent) sendOne(ctx context.Context, u *upload) (retryable bool, err error) {
+	body := u.meta
+	body.Profile = base64.StdEncoding.EncodeToString(u.data)
+	b, err := json.Marshal(&body)
+	if err != nil {
+		return false, err
+	}
+	code, resp, err := a.post(ctx, "/v1/profile", "application/json", b)
+	if err != nil {
+		// It is also retryable once cancelled by a stop, so it is spooled.
+		return true, err
+	}
+	if code != http.StatusOK {
+		return retryableCode(code), fmt.Errorf("response is not ok: %s", resp)
+	}
+	return false, nil
+}
+
+type batchResult struct {
+	ProfileID string `json:"profile_id"`
+	Code      int    `json:"code"`
+	Error     string `json:"error"`
+}
+
+type receiveProfileBatchDetail struct {
+	ResultList []batchResult `json:"result_list"`
+}
+
+// sendBatch sends the uploads to /v2/profile in one multipart request,
+// the i-th meta field describes the i-th profile file.
+func (a *Agent) sendBatch(ctx context.Context, uploads []*upload) ([]*upload, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, u := range uploads {
+		meta, err := json.Marshal(&u.meta)
+		if err != nil {
+			return nil, err
+		}
+		if err := w.WriteField("meta", string(meta)); err != nil {
+			return nil, err
+		}
+		fw, err := w.CreateFormFile("profile", u.meta.ProfileType)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(u.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	code, resp, err := a.post(ctx, "/v2/profile", w.FormDataContentType(), buf.Bytes())
+	if err != nil {
+		return uploads, err
+	}
+	if code != http.StatusOK {
+		err := fmt.Errorf("response is not ok: %s", resp)
+		if retryableCode(code) {
+			return uploads, err
+		}
+		return nil, err
+	}
+
+	var detail receiveProfileBatchDetail
+	if err := json.Unmarshal(resp, &detail); err != nil {
+		return nil, fmt.Errorf("fail to decode response: %w", err)
+	}
+	if len(detail.ResultList) != len(uploads) {
+		return nil, errors.New("result count mismatch")
+	}
+	var (
+		retry    []*upload
+		firstErr error
+	)
+	for i, result := range detail.ResultList {
+		if result.Code == http.StatusOK {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = fmt.Errorf("profile[%s] is not ok: %d %s", uploads[i].meta.ProfileType, result.Code, result.Error)
+		}
+		if retryableCode(result.Code) {
+			retry = append(retry, uploads[i])
+		}
+	}
+	return retry, firstErr
+}
+
+// enqueue adds an upload to the batch, a full batch is sent at once.
+func (a *Agent) enqueue(u *upload) {
+	a.batchMu.Lock()
+	a.batch = append(a.batch, u)
+	full := len(a.batch) >= a.o.BatchSize
+	a.batchMu.Unlock()
+	if full {
+		select {
+		case a.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// onBatch sends the batch every BatchWait or once it is full.
+func (a *Agent) onBatch(ctx context.Context) {
+	ti := time.NewTicker(a.o.BatchWait)
+	defer ti.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ti.C:
+		case <-a.flush:
+		}
+		a.flushBatch()
+	}
+}
+
+// flushBatch sends the whole batch, a stop does not cancel the sending
+// as the profiles would be lost, it times out instead.
+func (a *Agent) flushBatch() {
+	ctx, cancel := context.WithTimeout(context.Background(), batchFlushTimeout)
+	defer cancel()
+	for {
+		a.batchMu.Lock()
+		n := len(a.batch)
+		if n > a.o.BatchSize {
+			n = a.o.BatchSize
+		}
+		uploads := a.batch[:n:n]
+		a.batch = a.batch[n:]
+		a.batchMu.Unlock()
+		if len(uploads) == 0 {
+			return
+		}
+		if err := a.deliver(ctx, uploads); err != nil {
+			a.logger.Warn(fmt.Sprintf("fail to send %d profiles", len(uploads)), zap.Error(err))
+		}
+	}
+}
+
+// onRetry resends the spooled uploads, backing off while the collector is down.
+func (a *Agent) onRetry(ctx context.Context) {
+	backoff := minRetryBackoff
+	ti := time.NewTimer(backoff)
+	defer ti.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ti.C:
+			backoff = a.resend(ctx, backoff)
+			ti.Reset(backoff)
+		}
+	}
+}
+
+// resend sends the spooled uploads from the oldest until one fails,
+// it returns the backoff before the next try.
+func (a *Agent) resend(ctx context.Context, backoff time.Duration) time.Duration {
+	for {
+		e, b, err := a.spool.peek()
+		if e == nil {
+			return minRetryBackoff
+		}
+		var u *upload
+		if err == nil {
+			u, err = decodeUpload(b)
+		}
+		if err != nil {
+			a.logger.Warn("drop a broken spooled profile", zap.Error(err))
+			a.spool.remove(e)
+			continue
+		}
+		retry, err := a.send(ctx, []*upload{u})
+		if ctx.Err() != nil {
+			// Stopped, keep it for the next start.
+			return backoff
+		}
+		if len(retry) > 0 {
+			backoff *= 2
+			if backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+			return backoff
+		}
+		if err != nil {
+			a.logger.Warn("drop a spooled profile rejected by the collector", zap.Error(err))
+		}
+		a.spool.remove(e)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/collector/app"
+	"github.com/xiaojiaoyu100/profiler/collector/config/source"
+	"github.com/xiaojiaoyu100/profiler/collector/env"
+	"github.com/xiaojiaoyu100/profiler/script"
+)
+
+// Set by ldflags, e.g.
+//
+//	go build -ldflags "-X main.version=v1.0.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date +%FT%T%z)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+const usage = `Usage: collector <command> [flags]
+
+Commands:
+  serve         run the collector
+  init-storage  create the tablestore table and its search index
+  version       print the build information
+
+Run "collector <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "serve":
+		err = serve(os.Args[2:])
+	case "init-storage":
+		err = initStorage(os.Args[2:])
+	case "version":
+		printVersion()
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(os.Stdout, usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func buildOption() *app.BuildOption {
+	return &app.BuildOption{
+		Service:       "collector",
+		CodeVersion:   version,
+		GoVersion:     runtime.Version(),
+		BuildDateTime: buildTime,
+		GitCommitHash: commit,
+	}
+}
+
+func printVersion() {
+	o := buildOption()
+	fmt.Printf("version:    %s\n", o.CodeVersion)
+	fmt.Printf("commit:     %s\n", o.GitCommitHash)
+	fmt.Printf("build time: %s\n", o.BuildDateTime)
+	fmt.Printf("go version: %s\n", o.GoVersion)
+}
+
+// configFlags selects the config source: a config file, Aliyun ACM, or
+// environment variables when neither is given.
+type configFlags struct {
+	file      string
+	envPrefix string
+	acm       app.ACMOption
+}
+
+func (f *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.file, "config", "", "yaml or json config file, hot reloaded on change")
+	fs.StringVar(&f.envPrefix, "env-prefix", "PROFILER", "prefix of the config environment variables, used when neither -config nor -acm-addr is set")
+	fs.StringVar(&f.acm.Addr, "acm-addr", "", "acm address")
+	fs.StringVar(&f.acm.Tenant, "acm-tenant", "", "acm tenant")
+	fs.StringVar(&f.acm.Group, "acm-group", "", "acm group")
+	fs.StringVar(&f.acm.AccessKey, "acm-access-key", "", "acm access key")
+	fs.StringVar(&f.acm.SecretKey, "acm-secret-key", "", "acm secret key")
+	fs.StringVar(&f.acm.KmsRegionID, "kms-region-id", "", "kms region id")
+	fs.StringVar(&f.acm.KmsAccessKey, "kms-access-key", "", "kms access key")
+	fs.StringVar(&f.acm.KmsSecretKey, "kms-secret-key", "", "kms secret key")
+}
+
+func (f *configFlags) setter() (app.Setter, error) {
+	switch {
+	case f.file != "" && f.acm.Addr != "":
+		return nil, errors.New("-config and -acm-addr are exclusive")
+	case f.file != "":
+		return app.WithConfigSource(source.NewFile(f.file)), nil
+	case f.acm.Addr != "":
+		return app.WithACMCOption(&f.acm), nil
+	default:
+		return app.WithConfigSource(source.NewEnv(f.envPrefix)), nil
+	}
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var cf configFlags
+	cf.register(fs)
+	addr := fs.String("addr", "", "listen address, overrides the addr of the server config")
+	grpcAddr := fs.String("grpc-addr", "", "gRPC listen address, overrides the grpc_addr of the server config")
+	_ = fs.Parse(args)
+
+	setter, err := cf.setter()
+	if err != nil {
+		return err
+	}
+	a, cleanup, err := app.New(
+		setter,
+		app.WithAddr(*addr),
+		app.WithGRPCAddr(*grpcAddr),
+		app.WithBuildOption(buildOption()),
+	)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := a.Init(); err != nil {
+		return err
+	}
+	a.Run()
+	return nil
+}
+
+func initStorage(args []string) error {
+	fs := flag.NewFlagSet("init-storage", flag.ExitOnError)
+	var cf configFlags
+	cf.register(fs)
+	timeout := fs.Duration("timeout", time.Second*30, "max time to wait for the storage config")
+	_ = fs.Parse(args)
+
+	setter, err := cf.setter()
+	if err != nil {
+		return err
+	}
+	a, cleanup, err := app.New(
+		setter,
+		app.WithOnlyLoadConfig(),
+		app.WithBuildOption(buildOption()),
+	)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if err := a.Init(); err != nil {
+		return err
+	}
+
+	e := env.Instance()
+	e.SetLogger(&env.Logger{Logger: a.Logger()})
+
+	// Some config sources call the handlers asynchronously.
+	deadline := time.Now().Add(*timeout)
+	for e.MetaIndex() == nil {
+		if time.Now().After(deadline) {
+			return errors.New("no meta index configured")
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	script.InitOTS(e)
+	return nil
+}
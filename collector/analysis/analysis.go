@@ -0,0 +1,53 @@
+// Package analysis turns pprof profiles into the reports the collector responds.
+package analysis
+
+import (
+	"fmt"
+
+	gprofile "github.com/google/pprof/profile"
+)
+
+// SampleIndex returns the index of the sample type named sampleType,
+// an empty sampleType selects the default one as go tool pprof does.
+func SampleIndex(p *gprofile.Profile, sampleType string) (int, error) {
+	if len(p.SampleType) == 0 {
+		return 0, fmt.Errorf("profile has no sample type")
+	}
+	if sampleType == "" {
+		sampleType = p.DefaultSampleType
+	}
+	if sampleType == "" {
+		return len(p.SampleType) - 1, nil
+	}
+	for i, t := range p.SampleType {
+		if t.Type == sampleType {
+			return i, nil
+		}
+	}
+	var names []string
+	for _, t := range p.SampleType {
+		names = append(names, t.Type)
+	}
+	return 0, fmt.Errorf("sample type %s not found, available: %v", sampleType, names)
+}
+
+// frameNames returns the function names of a sample from the leaf to the root,
+// inlined functions get their own frames.
+func frameNames(s *gprofile.Sample) []string {
+	var names []string
+	for _, loc := range s.Location {
+		if len(loc.Line) == 0 {
+			names = append(names, fmt.Sprintf("0x%x", loc.Address))
+			continue
+		}
+		// Line[0] is the innermost inlined function.
+		for _, line := range loc.Line {
+			if line.Function == nil {
+				names = append(names, fmt.Sprintf("0x%x", loc.Address))
+				continue
+			}
+			names = append(names, line.Function.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,187 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+
+	gprofile "github.com/google/pprof/profile"
+)
+
+// newTestProfile builds a cpu profile from folded stacks, e.g. "main;a;b" is
+// a sample whose leaf is b.
+func newTestProfile(stacks map[string]int64) *gprofile.Profile {
+	p := &gprofile.Profile{
+		SampleType: []*gprofile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		PeriodType: &gprofile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     1,
+	}
+	functions := make(map[string]*gprofile.Function)
+	locations := make(map[string]*gprofile.Location)
+	for stack, value := range stacks {
+		names := strings.Split(stack, ";")
+		s := &gprofile.Sample{Value: []int64{value, value * 10}}
+		for i := len(names) - 1; i >= 0; i-- {
+			name := names[i]
+			loc, ok := locations[name]
+			if !ok {
+				fn := &gprofile.Function{ID: uint64(len(functions) + 1), Name: name}
+				functions[name] = fn
+				p.Function = append(p.Function, fn)
+				loc = &gprofile.Location{ID: uint64(len(locations) + 1), Line: []gprofile.Line{{Function: fn}}}
+				locations[name] = loc
+				p.Location = append(p.Location, loc)
+			}
+			s.Location = append(s.Location, loc)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p
+}
+
+func TestSampleIndex(t *testing.T) {
+	p := newTestProfile(map[string]int64{"main": 1})
+	if i, err := SampleIndex(p, ""); err != nil || i != 1 {
+		t.Fatalf("default sample index = %d, %v", i, err)
+	}
+	if i, err := SampleIndex(p, "samples"); err != nil || i != 0 {
+		t.Fatalf("sample index = %d, %v", i, err)
+	}
+	if _, err := SampleIndex(p, "alloc_space"); err == nil {
+		t.Fatal("unknown sample type is selected")
+	}
+}
+
+func TestTopDiff(t *testing.T) {
+	base := newTestProfile(map[string]int64{
+		"main;a": 50,
+		"main;b": 50,
+	})
+	target := newTestProfile(map[string]int64{
+		"main;a":   20,
+		"main;b":   20,
+		"main;b;c": 60,
+	})
+	list := TopDiff(base, target, 0, 0, 1)
+	if len(list) != 1 {
+		t.Fatalf("len = %d", len(list))
+	}
+	if list[0].Name != "c" || list[0].FlatShareDelta != 0.6 || list[0].BaseFlat != 0 {
+		t.Fatalf("unexpected top: %+v", list[0])
+	}
+	list = TopDiff(base, target, 0, 0, 0)
+	if len(list) != 4 || list[len(list)-1].Name != "main" {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	diff, err := Diff(base, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, s := range diff.Sample {
+		total += s.Value[0]
+	}
+	if total != 0 {
+		t.Fatalf("diff total = %d, want 0", total)
+	}
+}
+
+func TestFlameGraph(t *testing.T) {
+	p := newTestProfile(map[string]int64{
+		"main;a":   3,
+		"main;a;b": 2,
+		"main;c":   5,
+	})
+	root := FlameGraph(p, 0)
+	if root.Total != 10 || len(root.Children) != 1 {
+		t.Fatalf("unexpected root: %+v", root)
+	}
+	main := root.Children[0]
+	if main.Name != "main" || main.Total != 10 || main.Self != 0 || len(main.Children) != 2 {
+		t.Fatalf("unexpected main: %+v", main)
+	}
+	a := main.Children[0]
+	if a.Name != "a" || a.Self != 3 || a.Total != 5 || a.Children[0].Name != "b" {
+		t.Fatalf("unexpected a: %+v", a)
+	}
+}
+
+func TestToSpeedscope(t *testing.T) {
+	p := newTestProfile(map[string]int64{
+		"main;a": 3,
+	})
+	s := ToSpeedscope(p, 1, "test")
+	if len(s.Profiles) != 1 || s.Profiles[0].Unit != "nanoseconds" || s.Profiles[0].EndValue != 30 {
+		t.Fatalf("unexpected profiles: %+v", s.Profiles)
+	}
+	stack := s.Profiles[0].Samples[0]
+	if len(stack) != 2 || s.Shared.Frames[stack[0]].Name != "main" || s.Shared.Frames[stack[1]].Name != "a" {
+		t.Fatalf("unexpected stack: %v, frames: %v", stack, s.Shared.Frames)
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	p := newTestProfile(map[string]int64{
+		"main;a":   3,
+		"main;a;b": 2,
+	})
+	var b strings.Builder
+	if err := WriteFolded(&b, p, 0); err != nil {
+		t.Fatal(err)
+	}
+	if want := "main;a 3\nmain;a;b 2\n"; b.String() != want {
+		t.Fatalf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestTop(t *testing.T) {
+	p := newTestProfile(map[string]int64{
+		"main;a":   3,
+		"main;a;b": 2,
+		"main;c":   5,
+	})
+	rows := Top(p, 0, SortCum, 2)
+	if len(rows) != 2 || rows[0].Name != "main" || rows[1].Name != "a" {
+		t.Fatalf("unexpected rows: %+v %+v", rows[0], rows[1])
+	}
+	rows = Top(p, 0, SortFlat, 0)
+	if rows[0].Name != "c" || rows[0].FlatPercent != 50 || rows[1].SumPercent != 80 {
+		t.Fatalf("unexpected rows: %+v %+v", rows[0], rows[1])
+	}
+	if got := formatValue(1500000, "nanoseconds"); got != "1.50ms" {
+		t.Fatalf("formatValue = %s", got)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	p := newTestProfile(map[string]int64{
+		"main;a": 10,
+		"main;b": 20,
+		"main;c": 30,
+	})
+	for _, s := range p.Sample {
+		switch s.Location[0].Line[0].Function.Name {
+		case "a":
+			s.Label = map[string][]string{"endpoint": {"/checkout"}}
+		case "b":
+			s.Label = map[string][]string{"endpoint": {"/cart"}}
+		}
+	}
+
+	groups := GroupByLabel(p, 0, "endpoint")
+	if len(groups) != 3 || groups[0].Value != "" || groups[0].Total != 30 || groups[2].Value != "/checkout" {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+
+	FilterLabels(p, map[string][]string{"endpoint": {"/checkout", "/cart"}})
+	if len(p.Sample) != 2 {
+		t.Fatalf("%d samples left, want 2", len(p.Sample))
+	}
+	FilterLabels(p, map[string][]string{"endpoint": {"/checkout"}})
+	if len(p.Sample) != 1 || p.Sample[0].Value[0] != 10 {
+		t.Fatalf("unexpected samples: %+v", p.Sample)
+	}
+}
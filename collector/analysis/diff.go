@@ -0,0 +1,133 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+
+	gprofile "github.com/google/pprof/profile"
+)
+
+// Diff returns target minus base, the samples of base are negated and
+// labeled pprof::base like go tool pprof -diff_base does.
+func Diff(base, target *gprofile.Profile) (*gprofile.Profile, error) {
+	negBase := base.Copy()
+	negBase.SetLabel("pprof::base", []string{"true"})
+	negBase.Scale(-1)
+	return gprofile.Merge([]*gprofile.Profile{target, negBase})
+}
+
+type FunctionDiff struct {
+	Name            string  `json:"name"`
+	BaseFlat        int64   `json:"base_flat"`
+	TargetFlat      int64   `json:"target_flat"`
+	BaseCum         int64   `json:"base_cum"`
+	TargetCum       int64   `json:"target_cum"`
+	BaseFlatShare   float64 `json:"base_flat_share"`
+	TargetFlatShare float64 `json:"target_flat_share"`
+	FlatShareDelta  float64 `json:"flat_share_delta"`
+	BaseCumShare    float64 `json:"base_cum_share"`
+	TargetCumShare  float64 `json:"target_cum_share"`
+	CumShareDelta   float64 `json:"cum_share_delta"`
+}
+
+type functionValue struct {
+	flat int64
+	cum  int64
+}
+
+// functionValues sums the flat and cum values of every function.
+func functionValues(p *gprofile.Profile, sampleIndex int) (map[string]*functionValue, int64) {
+	ret := make(map[string]*functionValue)
+	var total int64
+	get := func(name string) *functionValue {
+		v, ok := ret[name]
+		if !ok {
+			v = &functionValue{}
+			ret[name] = v
+		}
+		return v
+	}
+	for _, s := range p.Sample {
+		value := s.Value[sampleIndex]
+		total += value
+		names := frameNames(s)
+		if len(names) == 0 {
+			continue
+		}
+		get(names[0]).flat += value
+		// Recursive functions count once in cum.
+		seen := make(map[string]bool, len(names))
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			get(name).cum += value
+		}
+	}
+	return ret, total
+}
+
+func share(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(v) / float64(total)
+}
+
+// TopDiff returns at most n functions whose flat or cum share of the total
+// changed most from base to target, the shares make windows or versions of
+// different traffic comparable.
+func TopDiff(base, target *gprofile.Profile, baseIndex, targetIndex int, n int) []*FunctionDiff {
+	baseValues, baseTotal := functionValues(base, baseIndex)
+	targetValues, targetTotal := functionValues(target, targetIndex)
+
+	list := make([]*FunctionDiff, 0, len(targetValues))
+	add := func(name string) {
+		b, ok := baseValues[name]
+		if !ok {
+			b = &functionValue{}
+		}
+		t, ok := targetValues[name]
+		if !ok {
+			t = &functionValue{}
+		}
+		d := &FunctionDiff{
+			Name:            name,
+			BaseFlat:        b.flat,
+			TargetFlat:      t.flat,
+			BaseCum:         b.cum,
+			TargetCum:       t.cum,
+			BaseFlatShare:   share(b.flat, baseTotal),
+			TargetFlatShare: share(t.flat, targetTotal),
+			BaseCumShare:    share(b.cum, baseTotal),
+			TargetCumShare:  share(t.cum, targetTotal),
+		}
+		d.FlatShareDelta = d.TargetFlatShare - d.BaseFlatShare
+		d.CumShareDelta = d.TargetCumShare - d.BaseCumShare
+		list = append(list, d)
+	}
+	for name := range targetValues {
+		add(name)
+	}
+	for name := range baseValues {
+		if _, ok := targetValues[name]; !ok {
+			add(name)
+		}
+	}
+
+	change := func(d *FunctionDiff) float64 {
+		return math.Max(math.Abs(d.FlatShareDelta), math.Abs(d.CumShareDelta))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		ci, cj := change(list[i]), change(list[j])
+		if ci != cj {
+			return ci > cj
+		}
+		return list[i].Name < list[j].Name
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
@@ -0,0 +1,66 @@
+package analysis
+
+import (
+	"sort"
+
+	gprofile "github.com/google/pprof/profile"
+)
+
+// FlameNode is a frame of a flame graph, Total includes Self and the
+// totals of the children.
+type FlameNode struct {
+	Name     string       `json:"name"`
+	Self     int64        `json:"self"`
+	Total    int64        `json:"total"`
+	Children []*FlameNode `json:"children"`
+
+	index map[string]*FlameNode
+}
+
+func (n *FlameNode) child(name string) *FlameNode {
+	if n.index == nil {
+		n.index = make(map[string]*FlameNode)
+	}
+	c, ok := n.index[name]
+	if !ok {
+		c = &FlameNode{Name: name}
+		n.index[name] = c
+		n.Children = append(n.Children, c)
+	}
+	return c
+}
+
+// FlameGraph builds the flame graph tree of a profile,
+// the root is a synthetic node named root.
+func FlameGraph(p *gprofile.Profile, sampleIndex int) *FlameNode {
+	root := &FlameNode{Name: "root"}
+	for _, s := range p.Sample {
+		value := s.Value[sampleIndex]
+		if value == 0 {
+			continue
+		}
+		names := frameNames(s)
+		node := root
+		node.Total += value
+		for i := len(names) - 1; i >= 0; i-- {
+			node = node.child(names[i])
+			node.Total += value
+		}
+		node.Self += value
+	}
+	root.sort()
+	return root
+}
+
+func (n *FlameNode) sort() {
+	n.index = nil
+	if n.Children == nil {
+		n.Children = []*FlameNode{}
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
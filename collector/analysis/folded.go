@@ -0,0 +1,44 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	gprofile "github.com/google/pprof/profile"
+)
+
+// WriteFolded writes the folded stacks of a profile, one stack per line with
+// the frames from the root to the leaf joined by semicolons and followed by
+// the value, the input format of flamegraph.pl. Stacks with non positive
+// values are dropped.
+func WriteFolded(w io.Writer, p *gprofile.Profile, sampleIndex int) error {
+	values := make(map[string]int64)
+	for _, s := range p.Sample {
+		value := s.Value[sampleIndex]
+		if value == 0 {
+			continue
+		}
+		names := frameNames(s)
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
+		}
+		values[strings.Join(names, ";")] += value
+	}
+
+	stacks := make([]string, 0, len(values))
+	for stack, value := range values {
+		if value <= 0 {
+			continue
+		}
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, values[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
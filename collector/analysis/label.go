@@ -0,0 +1,81 @@
+package analysis
+
+import (
+	"sort"
+
+	gprofile "github.com/google/pprof/profile"
+)
+
+// FilterLabels removes the samples of p that do not carry every key of labels
+// with one of its values, the labels are set by pprof.Do or pprof.SetGoroutineLabels.
+func FilterLabels(p *gprofile.Profile, labels map[string][]string) {
+	if len(labels) == 0 {
+		return
+	}
+	samples := p.Sample[:0]
+	for _, s := range p.Sample {
+		if matchLabels(s, labels) {
+			samples = append(samples, s)
+		}
+	}
+	for i := len(samples); i < len(p.Sample); i++ {
+		p.Sample[i] = nil
+	}
+	p.Sample = samples
+}
+
+func matchLabels(s *gprofile.Sample, labels map[string][]string) bool {
+	for k, values := range labels {
+		found := false
+		for _, v := range s.Label[k] {
+			for _, value := range values {
+				if v == value {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type LabelGroup struct {
+	Value   string  `json:"value"` // 空值表示没有这个label的样本
+	Total   int64   `json:"total"`
+	Percent float64 `json:"percent"`
+}
+
+// GroupByLabel sums the samples by the value of the label key,
+// the groups are sorted by total descending.
+func GroupByLabel(p *gprofile.Profile, sampleIndex int, key string) []LabelGroup {
+	totals := make(map[string]int64)
+	var total int64
+	for _, s := range p.Sample {
+		var value string
+		if values := s.Label[key]; len(values) > 0 {
+			value = values[0]
+		}
+		v := s.Value[sampleIndex]
+		totals[value] += v
+		total += v
+	}
+
+	groups := make([]LabelGroup, 0, len(totals))
+	for value, v := range totals {
+		groups = append(groups, LabelGroup{
+			Value:   value,
+			Total:   v,
+			Percent: percent(v, total),
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Total != groups[j].Total {
+			return groups[i].Total > groups[j].Total
+		}
+		return groups[i].Value < groups[j].Value
+	})
+	return groups
+}
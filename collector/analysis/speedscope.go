@@ -0,0 +1,90 @@
+package analysis
+
+import (
+	gprofile "github.com/google/pprof/profile"
+)
+
+const speedscopeSchema = "https://www.speedscope.app/file-format-schema.json"
+
+// Speedscope is the file format of https://www.speedscope.app.
+type Speedscope struct {
+	Schema             string              `json:"$schema"`
+	Shared             SpeedscopeShared    `json:"shared"`
+	Profiles           []SpeedscopeProfile `json:"profiles"`
+	Name               string              `json:"name"`
+	ActiveProfileIndex int                 `json:"activeProfileIndex"`
+	Exporter           string              `json:"exporter"`
+}
+
+type SpeedscopeShared struct {
+	Frames []SpeedscopeFrame `json:"frames"`
+}
+
+type SpeedscopeFrame struct {
+	Name string `json:"name"`
+}
+
+type SpeedscopeProfile struct {
+	Type       string  `json:"type"`
+	Name       string  `json:"name"`
+	Unit       string  `json:"unit"`
+	StartValue int64   `json:"startValue"`
+	EndValue   int64   `json:"endValue"`
+	Samples    [][]int `json:"samples"`
+	Weights    []int64 `json:"weights"`
+}
+
+// speedscopeUnit maps a pprof unit to the units speedscope knows.
+func speedscopeUnit(unit string) string {
+	switch unit {
+	case "nanoseconds", "microseconds", "milliseconds", "seconds", "bytes":
+		return unit
+	default:
+		return "none"
+	}
+}
+
+// ToSpeedscope converts a sample type of a profile into a sampled speedscope
+// profile, samples with non positive values are dropped as speedscope can
+// not show them.
+func ToSpeedscope(p *gprofile.Profile, sampleIndex int, name string) *Speedscope {
+	sampleType := p.SampleType[sampleIndex]
+	ret := &Speedscope{
+		Schema:   speedscopeSchema,
+		Name:     name,
+		Exporter: "profiler",
+	}
+	frameIndex := make(map[string]int)
+	profile := SpeedscopeProfile{
+		Type:    "sampled",
+		Name:    sampleType.Type,
+		Unit:    speedscopeUnit(sampleType.Unit),
+		Samples: [][]int{},
+		Weights: []int64{},
+	}
+	for _, s := range p.Sample {
+		value := s.Value[sampleIndex]
+		if value <= 0 {
+			continue
+		}
+		names := frameNames(s)
+		stack := make([]int, 0, len(names))
+		for i := len(names) - 1; i >= 0; i-- {
+			idx, ok := frameIndex[names[i]]
+			if !ok {
+				idx = len(ret.Shared.Frames)
+				frameIndex[names[i]] = idx
+				ret.Shared.Frames = append(ret.Shared.Frames, SpeedscopeFrame{Name: names[i]})
+			}
+			stack = append(stack, idx)
+		}
+		profile.Samples = append(profile.Samples, stack)
+		profile.Weights = append(profile.Weights, value)
+		profile.EndValue += value
+	}
+	if ret.Shared.Frames == nil {
+		ret.Shared.Frames = []SpeedscopeFrame{}
+	}
+	ret.Profiles = []SpeedscopeProfile{profile}
+	return ret
+}
@@ -0,0 +1,116 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	gprofile "github.com/google/pprof/profile"
+)
+
+const (
+	SortFlat = "flat"
+	SortCum  = "cum"
+)
+
+type TopRow struct {
+	Name        string  `json:"name"`
+	Flat        int64   `json:"flat"`
+	FlatPercent float64 `json:"flat_percent"`
+	SumPercent  float64 `json:"sum_percent"`
+	Cum         int64   `json:"cum"`
+	CumPercent  float64 `json:"cum_percent"`
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func percent(v, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(v) * 100 / float64(total)
+}
+
+// Top returns the functions sorted by flat or cum like go tool pprof -top,
+// n limits the rows if it is positive.
+func Top(p *gprofile.Profile, sampleIndex int, sortBy string, n int) []*TopRow {
+	values, _ := functionValues(p, sampleIndex)
+	var total int64
+	for _, s := range p.Sample {
+		total += abs(s.Value[sampleIndex])
+	}
+
+	rows := make([]*TopRow, 0, len(values))
+	for name, v := range values {
+		rows = append(rows, &TopRow{
+			Name:        name,
+			Flat:        v.flat,
+			FlatPercent: percent(v.flat, total),
+			Cum:         v.cum,
+			CumPercent:  percent(v.cum, total),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		vi, vj := abs(rows[i].Flat), abs(rows[j].Flat)
+		if sortBy == SortCum {
+			vi, vj = abs(rows[i].Cum), abs(rows[j].Cum)
+		}
+		if vi != vj {
+			return vi > vj
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	if n > 0 && len(rows) > n {
+		rows = rows[:n]
+	}
+	var sum int64
+	for _, row := range rows {
+		sum += row.Flat
+		row.SumPercent = percent(sum, total)
+	}
+	return rows
+}
+
+// WriteTop writes the rows as the text table of go tool pprof -top.
+func WriteTop(w io.Writer, rows []*TopRow, unit string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "flat\tflat%%\tsum%%\tcum\tcum%%\t\n")
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%.2f%%\t%.2f%%\t%s\t%.2f%%\t %s\n",
+			formatValue(row.Flat, unit), row.FlatPercent, row.SumPercent,
+			formatValue(row.Cum, unit), row.CumPercent, row.Name)
+	}
+	return tw.Flush()
+}
+
+// formatValue scales time and memory values to a readable unit.
+func formatValue(v int64, unit string) string {
+	type scale struct {
+		factor float64
+		suffix string
+	}
+	var scales []scale
+	switch unit {
+	case "nanoseconds":
+		scales = []scale{{1e9, "s"}, {1e6, "ms"}, {1e3, "us"}, {1, "ns"}}
+	case "bytes":
+		scales = []scale{{1 << 30, "GB"}, {1 << 20, "MB"}, {1 << 10, "kB"}, {1, "B"}}
+	default:
+		return fmt.Sprintf("%d", v)
+	}
+	if v == 0 {
+		return "0"
+	}
+	for _, s := range scales {
+		if float64(abs(v)) >= s.factor {
+			return fmt.Sprintf("%.2f%s", float64(v)/s.factor, s.suffix)
+		}
+	}
+	return fmt.Sprintf("%d%s", v, scales[len(scales)-1].suffix)
+}
@@ -8,32 +8,46 @@ import (
 
 	"github.com/xiaojiaoyu100/profiler/log"
 
+	"github.com/xiaojiaoyu100/profiler/collector/config/authconfig"
 	"github.com/xiaojiaoyu100/profiler/collector/config/tablestoreconfig"
 
 	"github.com/xiaojiaoyu100/profiler/collector/config/serverconfig"
 
 	"github.com/xiaojiaoyu100/profiler/collector/config/ossconfig"
 
+	"github.com/xiaojiaoyu100/profiler/collector/config/boltconfig"
+
+	"github.com/xiaojiaoyu100/profiler/collector/config/fsconfig"
+
+	"github.com/xiaojiaoyu100/profiler/collector/config/s3config"
+
 	"errors"
 	"sync"
 
 	aliacm "github.com/xiaojiaoyu100/aliyun-acm/v2"
-	"github.com/xiaojiaoyu100/aliyun-acm/v2/info"
-	"github.com/xiaojiaoyu100/aliyun-acm/v2/observer"
+	"github.com/xiaojiaoyu100/profiler/collector/config/scrapeconfig"
+	"github.com/xiaojiaoyu100/profiler/collector/config/source"
+	"github.com/xiaojiaoyu100/profiler/collector/scrape"
 	"github.com/xiaojiaoyu100/profiler/collector/server"
+	"github.com/xiaojiaoyu100/profiler/collector/server/grpcserver"
 	"go.uber.org/zap"
 )
 
 type App struct {
 	onlyLoadConfig bool
-	acmOption      *ACMOption
-	acmClient      *aliacm.Diamond
+	source         source.Source
+	addr           string
+	grpcAddr       string
 	buildOption    *BuildOption
 	logger         *zap.Logger
 
-	guardHttpServer sync.Mutex
-	httpServer      *server.HttpServer
-	exit            chan os.Signal
+	guardHttpServer    sync.Mutex
+	serverConfigLoaded bool
+	httpServer         *server.HttpServer
+	grpcServer         *grpcserver.GrpcServer
+	guardScraper       sync.Mutex
+	scraper            *scrape.Scraper
+	exit               chan os.Signal
 }
 
 type Setter func(app *App) error
@@ -52,8 +66,32 @@ func WithACMCOption(option *ACMOption) Setter {
 		if err != nil {
 			return fmt.Errorf("fail to create a acm aclient: %w", err)
 		}
-		app.acmOption = option
-		app.acmClient = client
+		app.source = source.NewACM(client, option.Group)
+		return nil
+	}
+}
+
+// WithConfigSource sets where the configs come from, e.g. source.NewFile
+// or source.NewEnv for deployments without ACM.
+func WithConfigSource(s source.Source) Setter {
+	return func(app *App) error {
+		app.source = s
+		return nil
+	}
+}
+
+// WithAddr overrides the addr of the server config.
+func WithAddr(addr string) Setter {
+	return func(app *App) error {
+		app.addr = addr
+		return nil
+	}
+}
+
+// WithGRPCAddr overrides the grpc_addr of the server config.
+func WithGRPCAddr(addr string) Setter {
+	return func(app *App) error {
+		app.grpcAddr = addr
 		return nil
 	}
 }
@@ -76,7 +114,7 @@ func New(setters ...Setter) (*App, func(), error) {
 
 	a := &App{
 		logger: logger,
-		exit:   make(chan os.Signal),
+		exit:   make(chan os.Signal, 1),
 	}
 	for _, setter := range setters {
 		if err := setter(a); err != nil {
@@ -86,13 +124,19 @@ func New(setters ...Setter) (*App, func(), error) {
 	if a.buildOption == nil {
 		return nil, nil, errors.New("no build option provided")
 	}
+	if a.source == nil {
+		return nil, nil, errors.New("no config source provided")
+	}
 	cleanup := func() {
+		if err := a.source.Close(); err != nil {
+			a.logger.Warn("fail to close the config source", zap.Error(err))
+		}
 	}
 	return a, cleanup, nil
 }
 
 func (a *App) Init() error {
-	if err := a.initACMClient(); err != nil {
+	if err := a.initConfigSource(); err != nil {
 		return err
 	}
 	a.initExit()
@@ -101,57 +145,89 @@ func (a *App) Init() error {
 
 func (a *App) initExit() {
 	go func() {
-		signal.Notify(a.exit, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
+		signal.Notify(a.exit, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 	}()
 }
 
 func (a *App) Run() {
 	<-a.exit
-}
-
-func (a *App) ACMGroup() string {
-	return a.acmOption.Group
+	a.guardHttpServer.Lock()
+	defer a.guardHttpServer.Unlock()
+	if a.httpServer.Running() {
+		a.httpServer.Close()
+	}
+	if a.grpcServer.Running() {
+		a.grpcServer.Close()
+	}
+	a.guardScraper.Lock()
+	defer a.guardScraper.Unlock()
+	a.scraper.Close()
+	a.scraper = nil
 }
 
 func (a *App) Logger() *zap.Logger {
 	return a.logger
 }
 
-func (a *App) registerObserverList() error {
+func (a *App) BuildOption() *BuildOption {
+	return a.buildOption
+}
+
+func (a *App) registerHandlerList() error {
 	var err error
-	var create = func(h observer.Handler, ii ...info.Info) {
-		if err != nil {
-			return
-		}
-		o, err := observer.New(
-			observer.WithInfo(ii...),
-			observer.WithHandler(h),
-		)
+	var register = func(dataID string, h source.Handler) {
 		if err != nil {
-			err = fmt.Errorf("observer new error:info:%+v err:%s", ii, err)
 			return
 		}
-		a.acmClient.Register(o)
+		err = a.source.Register(dataID, h)
 	}
 
-	create(initHttpServer(a), info.Info{Group: a.acmOption.Group, DataID: serverconfig.DataID})
-	create(initOSSClient(a), info.Info{Group: a.acmOption.Group, DataID: ossconfig.DataID})
-	create(initTablestoreClient(a), info.Info{Group: a.acmOption.Group, DataID: tablestoreconfig.DataID})
+	register(serverconfig.DataID, initHttpServer(a))
+	register(ossconfig.DataID, initOSSClient(a))
+	register(tablestoreconfig.DataID, initTablestoreClient(a))
+	register(s3config.DataID, initS3Store(a))
+	register(fsconfig.DataID, initFileSystemStore(a))
+	register(boltconfig.DataID, initBoltIndex(a))
+	register(scrapeconfig.DataID, initScraper(a))
+	register(authconfig.DataID, initAuth(a))
 
 	if err != nil {
-		a.logger.Debug("fail to create observers", zap.Error(err))
+		a.logger.Debug("fail to register config handlers", zap.Error(err))
 		return err
 	}
 	return nil
 }
 
-func (a *App) initACMClient() error {
-	if err := a.registerObserverList(); err != nil {
+func (a *App) initConfigSource() error {
+	if err := a.registerHandlerList(); err != nil {
 		return err
 	}
-	a.acmClient.NotifyAll()
-	a.acmClient.SetHook(func(err error) {
-		a.logger.Warn("acm internal error", zap.Error(err))
+	a.source.SetHook(func(err error) {
+		a.logger.Warn("config source internal error", zap.Error(err))
 	})
+	if err := a.source.Start(); err != nil {
+		return fmt.Errorf("fail to start the config source: %w", err)
+	}
+	a.initDefaultHttpServer()
 	return nil
 }
+
+// initDefaultHttpServer starts the servers from the flags when the source has
+// no valid Server config, as -addr is all a server needs. It is called after
+// Start, which has called the handlers of the loaded configs.
+func (a *App) initDefaultHttpServer() {
+	a.guardHttpServer.Lock()
+	loaded := a.serverConfigLoaded
+	a.guardHttpServer.Unlock()
+	if loaded {
+		return
+	}
+	if a.addr == "" {
+		a.logger.Warn(fmt.Sprintf("no %s config and no addr provided, the http server is not started", serverconfig.DataID))
+		return
+	}
+	a.logger.Info(fmt.Sprintf("no %s config, start the servers with the default config", serverconfig.DataID))
+	initHttpServer(a)(func(v interface{}) error {
+		return nil
+	})
+}
@@ -1,8 +1,9 @@
 package app
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
+	"path/filepath"
 	"time"
 
 	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore"
@@ -13,35 +14,65 @@ import (
 	"github.com/xiaojiaoyu100/profiler/collector/server/engine"
 
 	"github.com/xiaojiaoyu100/profiler/collector/config/serverconfig"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest/ingestpb"
+	"github.com/xiaojiaoyu100/profiler/collector/scrape"
 	"github.com/xiaojiaoyu100/profiler/collector/server"
+	"github.com/xiaojiaoyu100/profiler/collector/server/grpcserver"
+	"google.golang.org/grpc"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
-	"github.com/xiaojiaoyu100/aliyun-acm/v2/config"
-	"github.com/xiaojiaoyu100/aliyun-acm/v2/info"
-	"github.com/xiaojiaoyu100/aliyun-acm/v2/observer"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/config/authconfig"
+	"github.com/xiaojiaoyu100/profiler/collector/config/boltconfig"
+	"github.com/xiaojiaoyu100/profiler/collector/config/fsconfig"
 	"github.com/xiaojiaoyu100/profiler/collector/config/ossconfig"
+	"github.com/xiaojiaoyu100/profiler/collector/config/s3config"
+	"github.com/xiaojiaoyu100/profiler/collector/config/scrapeconfig"
+	"github.com/xiaojiaoyu100/profiler/collector/config/source"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/boltindex"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/fsblob"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/ossblob"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/otsindex"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/s3blob"
+	"github.com/xiaojiaoyu100/profiler/profile"
+	"github.com/xiaojiaoyu100/profiler/tlsutil"
 	"go.uber.org/zap"
 )
 
-func initHttpServer(a *App) observer.Handler {
-	return func(coll map[info.Info]*config.Config) {
+func initHttpServer(a *App) source.Handler {
+	return func(decode source.Decoder) {
 		dataID := serverconfig.DataID
 
-		a.Logger().Info(fmt.Sprintf("start to get config: group = %s, dataID = %s", a.ACMGroup(), dataID))
-
-		cc, ok := coll[info.Info{
-			Group:  a.ACMGroup(),
-			DataID: dataID,
-		}]
-		if !ok {
-			a.Logger().Info(fmt.Sprintf("fail to load, group = %s, dataID = %s", a.ACMGroup(), dataID))
-			return
-		}
+		a.Logger().Info(fmt.Sprintf("start to get config: dataID = %s", dataID))
 		c := &serverconfig.Config{}
-		if err := json.Unmarshal(cc.Content, c); err != nil {
-			a.Logger().Warn(fmt.Sprintf("fail to unmarshal, group = %s, dataID = %s", a.ACMGroup(), dataID), zap.Error(err))
+		if err := decode(c); err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to decode, dataID = %s", dataID), zap.Error(err))
 			return
 		}
+		a.guardHttpServer.Lock()
+		a.serverConfigLoaded = true
+		a.guardHttpServer.Unlock()
+		if a.addr != "" {
+			c.Addr = a.addr
+		}
+		if a.grpcAddr != "" {
+			c.GRPCAddr = a.grpcAddr
+		}
+
+		var tlsConfig *tls.Config
+		if c.CertFile != "" {
+			var err error
+			tlsConfig, err = tlsutil.ServerConfig(&tlsutil.Option{
+				CertFile:          c.CertFile,
+				KeyFile:           c.KeyFile,
+				CAFile:            c.ClientCAFile,
+				RequireClientCert: c.RequireClientCert,
+			})
+			if err != nil {
+				a.Logger().Warn(fmt.Sprintf("fail to load the tls config, dataID = %s", dataID), zap.Error(err))
+				return
+			}
+		}
 
 		en := engine.Routes(engine.Engine(env.Instance()))
 
@@ -50,11 +81,12 @@ func initHttpServer(a *App) observer.Handler {
 			server.WithOption(&server.Option{
 				Addr:            c.Addr,
 				ShutdownTimeout: time.Duration(c.ShutdownTimeout) * time.Second,
+				TLSConfig:       tlsConfig,
 			}),
 			server.WithEngine(en),
 		)
 		if err != nil {
-			a.Logger().Warn(fmt.Sprintf("fail to create a http server, group = %s, dataID = %s", a.ACMGroup(), dataID), zap.Error(err))
+			a.Logger().Warn(fmt.Sprintf("fail to create a http server, dataID = %s", dataID), zap.Error(err))
 			return
 		}
 
@@ -65,6 +97,7 @@ func initHttpServer(a *App) observer.Handler {
 			}
 			httpServer.Run()
 			a.httpServer = httpServer
+			a.restartGrpcServer(c, tlsConfig)
 			a.guardHttpServer.Unlock()
 		}
 		env.Instance().SetLogger(&env.Logger{
@@ -73,64 +106,268 @@ func initHttpServer(a *App) observer.Handler {
 	}
 }
 
-func initTablestoreClient(a *App) observer.Handler {
-	return func(coll map[info.Info]*config.Config) {
+// restartGrpcServer replaces the running gRPC server with one on c.GRPCAddr,
+// the caller must hold guardHttpServer.
+func (a *App) restartGrpcServer(c *serverconfig.Config, tlsConfig *tls.Config) {
+	if a.grpcServer.Running() {
+		a.grpcServer.Close()
+		a.grpcServer = nil
+	}
+	if c.GRPCAddr == "" {
+		return
+	}
+	grpcServer, err := grpcserver.New(
+		grpcserver.WithLogger(a.Logger()),
+		grpcserver.WithOption(&grpcserver.Option{
+			Addr:            c.GRPCAddr,
+			ShutdownTimeout: time.Duration(c.ShutdownTimeout) * time.Second,
+			TLSConfig:       tlsConfig,
+		}),
+		grpcserver.WithServerOption(
+			grpc.ChainUnaryInterceptor(grpcserver.UnaryAuthInterceptor(env.Instance())),
+			grpc.ChainStreamInterceptor(grpcserver.StreamAuthInterceptor(env.Instance())),
+		),
+		grpcserver.WithService(func(s *grpc.Server) {
+			ingestpb.RegisterProfileServiceServer(s, grpcserver.NewProfileService(env.Instance()))
+		}),
+	)
+	if err != nil {
+		a.Logger().Warn("fail to create a grpc server", zap.Error(err))
+		return
+	}
+	if err := grpcServer.Run(); err != nil {
+		a.Logger().Warn("fail to run the grpc server", zap.Error(err))
+		return
+	}
+	a.grpcServer = grpcServer
+}
+
+func initTablestoreClient(a *App) source.Handler {
+	return func(decode source.Decoder) {
 		dataID := tablestoreconfig.DataID
 
-		a.Logger().Info(fmt.Sprintf("start to get config: group = %s, dataID = %s", a.ACMGroup(), dataID))
+		a.Logger().Info(fmt.Sprintf("start to get config: dataID = %s", dataID))
 
-		cc, ok := coll[info.Info{
-			Group:  a.ACMGroup(),
-			DataID: dataID,
-		}]
-		if !ok {
-			a.Logger().Info(fmt.Sprintf("fail to load, group = %s, dataID = %s", a.ACMGroup(), dataID))
-			return
-		}
 		c := &tablestoreconfig.TablestoreConfig{}
-		if err := json.Unmarshal(cc.Content, c); err != nil {
-			a.Logger().Warn(fmt.Sprintf("fail to unmarshal, group = %s, dataID = %s", a.ACMGroup(), dataID), zap.Error(err))
+		if err := decode(c); err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to decode, dataID = %s", dataID), zap.Error(err))
 			return
 		}
 
 		client := tablestore.NewClient(c.EndPoint, c.InstanceName, c.AccessKeyId, c.AccessKeySecret)
 
-		env.Instance().SetTablestoreClient(&env.TablestoreClient{
-			TableName: c.TableName,
-			Client:    client,
-		})
+		env.Instance().SetMetaIndex(otsindex.New(client, c.TableName))
 	}
 }
 
-func initOSSClient(a *App) observer.Handler {
-	return func(coll map[info.Info]*config.Config) {
+func initOSSClient(a *App) source.Handler {
+	return func(decode source.Decoder) {
 		dataID := ossconfig.DataID
 
-		a.Logger().Info(fmt.Sprintf("start to get config: group = %s, dataID = %s", a.ACMGroup(), dataID))
+		a.Logger().Info(fmt.Sprintf("start to get config: dataID = %s", dataID))
 
-		cc, ok := coll[info.Info{
-			Group:  a.ACMGroup(),
-			DataID: dataID,
-		}]
-		if !ok {
-			a.Logger().Info(fmt.Sprintf("fail to load, group = %s, dataID = %s", a.ACMGroup(), dataID))
-			return
-		}
 		c := &ossconfig.Config{}
-		if err := json.Unmarshal(cc.Content, c); err != nil {
-			a.Logger().Warn(fmt.Sprintf("fail to unmarshal, group = %s, dataID = %s", a.ACMGroup(), dataID), zap.Error(err))
+		if err := decode(c); err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to decode, dataID = %s", dataID), zap.Error(err))
 			return
 		}
 		client, err := oss.New(c.Endpoint, c.AccessKeyID, c.AccessKeySecret)
 		if err != nil {
-			a.Logger().Warn(fmt.Sprintf("fail to create a oss client, group = %s, dataID = %s", a.ACMGroup(), dataID), zap.Error(err))
+			a.Logger().Warn(fmt.Sprintf("fail to create a oss client, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		store, err := ossblob.New(client, c.Endpoint, c.Bucket, c.PathPrefix)
+		if err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to create a oss blob store, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		env.Instance().SetBlobStore(store)
+	}
+}
+
+func initFileSystemStore(a *App) source.Handler {
+	return func(decode source.Decoder) {
+		dataID := fsconfig.DataID
+
+		a.Logger().Info(fmt.Sprintf("start to get config: dataID = %s", dataID))
+
+		c := &fsconfig.Config{}
+		if err := decode(c); err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to decode, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		store, err := fsblob.New(c.RootDir, c.PathPrefix)
+		if err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to create a file system blob store, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		env.Instance().SetBlobStore(store)
+	}
+}
+
+func initBoltIndex(a *App) source.Handler {
+	return func(decode source.Decoder) {
+		dataID := boltconfig.DataID
+
+		a.Logger().Info(fmt.Sprintf("start to get config: dataID = %s", dataID))
+
+		c := &boltconfig.Config{}
+		if err := decode(c); err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to decode, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+
+		// bolt holds a file lock, so the open index of the same file is kept,
+		// and the old index of another file is closed after it is replaced.
+		old, _ := env.Instance().MetaIndex().(*boltindex.Index)
+		if old != nil && filepath.Clean(old.Path()) == filepath.Clean(c.Path) {
+			return
+		}
+		index, err := boltindex.New(c.Path)
+		if err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to create a bolt index, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		env.Instance().SetMetaIndex(index)
+		if old != nil {
+			if err := old.Close(); err != nil {
+				a.Logger().Warn(fmt.Sprintf("fail to close the old bolt index, dataID = %s", dataID), zap.Error(err))
+			}
+		}
+	}
+}
+
+func initS3Store(a *App) source.Handler {
+	return func(decode source.Decoder) {
+		dataID := s3config.DataID
+
+		a.Logger().Info(fmt.Sprintf("start to get config: dataID = %s", dataID))
+
+		c := &s3config.Config{}
+		if err := decode(c); err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to decode, dataID = %s", dataID), zap.Error(err))
 			return
 		}
-		env.Instance().SetOSSClient(&env.OSSClient{
-			EndPoint:   c.Endpoint,
-			Bucket:     c.Bucket,
-			PathPrefix: c.PathPrefix,
-			Client:     client,
+		store, err := s3blob.New(&s3blob.Option{
+			Endpoint:        c.Endpoint,
+			Region:          c.Region,
+			AccessKeyID:     c.AccessKeyID,
+			AccessKeySecret: c.AccessKeySecret,
+			Bucket:          c.Bucket,
+			PathPrefix:      c.PathPrefix,
+			UseSSL:          c.UseSSL,
+			PathStyle:       c.PathStyle,
 		})
+		if err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to create a s3 blob store, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		env.Instance().SetBlobStore(store)
+	}
+}
+
+func initScraper(a *App) source.Handler {
+	return func(decode source.Decoder) {
+		dataID := scrapeconfig.DataID
+
+		a.Logger().Info(fmt.Sprintf("start to get config: dataID = %s", dataID))
+
+		c := &scrapeconfig.Config{}
+		if err := decode(c); err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to decode, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		option := &scrape.Option{
+			Interval:    time.Duration(c.Interval) * time.Second,
+			Timeout:     time.Duration(c.Timeout) * time.Second,
+			CPUDuration: time.Duration(c.CPUDuration) * time.Second,
+		}
+		for _, t := range c.ProfileTypes {
+			option.ProfileTypes = append(option.ProfileTypes, profile.ParseType(t))
+		}
+		for _, t := range c.Targets {
+			option.Targets = append(option.Targets, scrape.Target{
+				Service:        t.Service,
+				ServiceVersion: t.ServiceVersion,
+				Addr:           t.Addr,
+				Tags:           t.Tags,
+				Tenant:         t.Tenant,
+			})
+		}
+		for _, sd := range c.FileSDConfigs {
+			option.Discoveries = append(option.Discoveries, scrape.Discovery{
+				Discoverer:      &scrape.FileDiscoverer{Files: sd.Files},
+				RefreshInterval: time.Duration(sd.RefreshInterval) * time.Second,
+			})
+		}
+		for _, sd := range c.DNSSDConfigs {
+			typ := sd.Type
+			if typ == "" {
+				typ = "SRV"
+			}
+			option.Discoveries = append(option.Discoveries, scrape.Discovery{
+				Discoverer:      &scrape.DNSDiscoverer{Names: sd.Names, Type: typ, Port: sd.Port},
+				RefreshInterval: time.Duration(sd.RefreshInterval) * time.Second,
+			})
+		}
+		for _, r := range c.RelabelConfigs {
+			option.RelabelRules = append(option.RelabelRules, &scrape.RelabelRule{
+				SourceLabels: r.SourceLabels,
+				Separator:    r.Separator,
+				Regex:        r.Regex,
+				TargetLabel:  r.TargetLabel,
+				Replacement:  r.Replacement,
+				Action:       scrape.RelabelAction(r.Action),
+			})
+		}
+		scraper, err := scrape.New(
+			scrape.WithLogger(a.Logger()),
+			scrape.WithEnv(env.Instance()),
+			scrape.WithOption(option),
+		)
+		if err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to create a scraper, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+
+		if !a.onlyLoadConfig {
+			a.guardScraper.Lock()
+			a.scraper.Close()
+			scraper.Run()
+			a.scraper = scraper
+			a.guardScraper.Unlock()
+		}
+	}
+}
+
+func initAuth(a *App) source.Handler {
+	return func(decode source.Decoder) {
+		dataID := authconfig.DataID
+
+		a.Logger().Info(fmt.Sprintf("start to get config: dataID = %s", dataID))
+
+		c := &authconfig.Config{}
+		if err := decode(c); err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to decode, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		if len(c.Keys) == 0 && len(c.Identities) == 0 {
+			env.Instance().SetAuthenticator(nil)
+			return
+		}
+		keys := make([]auth.Key, 0, len(c.Keys))
+		for _, k := range c.Keys {
+			keys = append(keys, auth.Key{Key: k.Key, Services: k.Services, Tenant: k.Tenant})
+		}
+		identities := make([]auth.Identity, 0, len(c.Identities))
+		for _, id := range c.Identities {
+			identities = append(identities, auth.Identity{Name: id.Name, Services: id.Services, Tenant: id.Tenant})
+		}
+		authenticator, err := auth.New(keys, identities)
+		if err != nil {
+			a.Logger().Warn(fmt.Sprintf("fail to create an authenticator, dataID = %s", dataID), zap.Error(err))
+			return
+		}
+		env.Instance().SetAuthenticator(authenticator)
 	}
 }
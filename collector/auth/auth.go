@@ -0,0 +1,173 @@
+// Package auth checks the API keys of the uploads, a key may only
+// upload the profiles of the services it is scoped to, and only
+// reads and writes the profiles of its tenant.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xiaojiaoyu100/profiler/tlsutil"
+)
+
+// AnyService scopes a key to every service.
+const AnyService = "*"
+
+type Key struct {
+	Key      string
+	Services []string
+	Tenant   string // empty for the default tenant
+}
+
+// Identity scopes the client certificates of a name, which is an uri SAN,
+// a dns SAN or the common name, see tlsutil.Identities.
+type Identity struct {
+	Name     string
+	Services []string
+	Tenant   string
+}
+
+// Scope is the tenant of a key and the services it may upload.
+type Scope struct {
+	tenant   string
+	any      bool
+	services map[string]struct{}
+}
+
+func (s *Scope) Tenant() string {
+	return s.tenant
+}
+
+func (s *Scope) Allow(service string) bool {
+	if s.any {
+		return true
+	}
+	_, ok := s.services[service]
+	return ok
+}
+
+// Authenticator keeps the scopes by the hash of the keys, so looking up
+// a key takes no time that depends on how much of it is right.
+type Authenticator struct {
+	scopes     map[[sha256.Size]byte]*Scope
+	identities map[string]*Scope
+}
+
+// tenantPattern keeps a tenant safe to be a path segment.
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ValidTenant reports whether tenant is the default tenant or a valid name.
+func ValidTenant(tenant string) bool {
+	return tenant == "" || tenantPattern.MatchString(tenant)
+}
+
+func newScope(tenant string, services []string) (*Scope, error) {
+	if !ValidTenant(tenant) {
+		return nil, fmt.Errorf("invalid tenant: %q", tenant)
+	}
+	scope := &Scope{
+		tenant:   tenant,
+		services: make(map[string]struct{}, len(services)),
+	}
+	for _, service := range services {
+		if service == AnyService {
+			scope.any = true
+		}
+		scope.services[service] = struct{}{}
+	}
+	return scope, nil
+}
+
+func New(keys []Key, identities []Identity) (*Authenticator, error) {
+	a := &Authenticator{
+		scopes:     make(map[[sha256.Size]byte]*Scope, len(keys)),
+		identities: make(map[string]*Scope, len(identities)),
+	}
+	for _, k := range keys {
+		if k.Key == "" {
+			return nil, errors.New("empty api key")
+		}
+		if len(k.Services) == 0 {
+			return nil, errors.New("no service provided for an api key")
+		}
+		scope, err := newScope(k.Tenant, k.Services)
+		if err != nil {
+			return nil, err
+		}
+		a.scopes[sha256.Sum256([]byte(k.Key))] = scope
+	}
+	for _, id := range identities {
+		if id.Name == "" {
+			return nil, errors.New("empty identity")
+		}
+		if len(id.Services) == 0 {
+			return nil, fmt.Errorf("no service provided for identity %s", id.Name)
+		}
+		scope, err := newScope(id.Tenant, id.Services)
+		if err != nil {
+			return nil, err
+		}
+		a.identities[id.Name] = scope
+	}
+	return a, nil
+}
+
+// AuthenticateCert returns the scope of the first identity of a verified
+// client certificate that is known, false if there is none.
+func (a *Authenticator) AuthenticateCert(cert *x509.Certificate) (*Scope, bool) {
+	for _, name := range tlsutil.Identities(cert) {
+		if scope, ok := a.identities[name]; ok {
+			return scope, true
+		}
+	}
+	return nil, false
+}
+
+// Authenticate returns the scope of the key, false if the key is unknown.
+func (a *Authenticator) Authenticate(key string) (*Scope, bool) {
+	if key == "" {
+		return nil, false
+	}
+	scope, ok := a.scopes[sha256.Sum256([]byte(key))]
+	return scope, ok
+}
+
+// BearerToken returns the token of an Authorization header value.
+func BearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+type scopeKey struct{}
+
+func NewContext(ctx context.Context, scope *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// AllowService reports whether the scope in ctx allows the service,
+// a ctx without a scope allows any service as the authentication is off.
+func AllowService(ctx context.Context, service string) bool {
+	scope, ok := ctx.Value(scopeKey{}).(*Scope)
+	if !ok {
+		return true
+	}
+	return scope.Allow(service)
+}
+
+// Tenant returns the tenant of the scope in ctx, the default tenant
+// if there is no scope.
+func Tenant(ctx context.Context) string {
+	scope, ok := ctx.Value(scopeKey{}).(*Scope)
+	if !ok {
+		return ""
+	}
+	return scope.tenant
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	a, err := New([]Key{
+		{Key: "k1", Services: []string{"gateway", "billing"}},
+		{Key: "k2", Services: []string{AnyService}, Tenant: "team-a"},
+	}, []Identity{
+		{Name: "spiffe://cluster/sa/gateway", Services: []string{"gateway"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scope, ok := a.Authenticate(BearerToken("Bearer k1"))
+	if !ok {
+		t.Fatal("k1 is not authenticated")
+	}
+	if !scope.Allow("gateway") || scope.Allow("order") {
+		t.Error("k1 has a wrong scope")
+	}
+	ctx := NewContext(context.Background(), scope)
+	if !AllowService(ctx, "billing") || AllowService(ctx, "order") {
+		t.Error("the scope in ctx is wrong")
+	}
+	if !AllowService(context.Background(), "order") {
+		t.Error("no scope should allow any service")
+	}
+	if Tenant(ctx) != "" || Tenant(context.Background()) != "" {
+		t.Error("k1 should be in the default tenant")
+	}
+
+	scope, ok = a.Authenticate(BearerToken("bearer k2"))
+	if !ok || !scope.Allow("order") {
+		t.Error("k2 should allow any service")
+	}
+	if Tenant(NewContext(context.Background(), scope)) != "team-a" {
+		t.Error("k2 should be in team-a")
+	}
+	for _, header := range []string{"", "k1", "Bearer k3", "Basic k1"} {
+		if _, ok := a.Authenticate(BearerToken(header)); ok {
+			t.Errorf("%q is authenticated", header)
+		}
+	}
+
+	u, _ := url.Parse("spiffe://cluster/sa/gateway")
+	scope, ok = a.AuthenticateCert(&x509.Certificate{URIs: []*url.URL{u}, Subject: pkix.Name{CommonName: "gw"}})
+	if !ok || !scope.Allow("gateway") || scope.Allow("billing") {
+		t.Error("the certificate has a wrong scope")
+	}
+	if _, ok := a.AuthenticateCert(&x509.Certificate{Subject: pkix.Name{CommonName: "gw"}}); ok {
+		t.Error("an unknown certificate is authenticated")
+	}
+
+	if _, err := New([]Key{{Key: "k"}}, nil); err == nil {
+		t.Error("no error for a key without services")
+	}
+	if _, err := New([]Key{{Key: "k", Services: []string{AnyService}, Tenant: "../a"}}, nil); err == nil {
+		t.Error("no error for an invalid tenant")
+	}
+}
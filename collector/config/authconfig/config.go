@@ -0,0 +1,22 @@
+package authconfig
+
+const (
+	DataID = "Auth"
+)
+
+type Config struct {
+	Keys       []Key      `json:"keys"`       // 上传profile的API key，keys和identities都为空时不鉴权
+	Identities []Identity `json:"identities"` // 通过mTLS客户端证书鉴权
+}
+
+type Key struct {
+	Key      string   `json:"key"`      // 通过Authorization: Bearer <key>传入
+	Services []string `json:"services"` // 允许上传的服务，*表示所有服务
+	Tenant   string   `json:"tenant"`   // 所属租户，只能读写该租户的profile，为空时是默认租户
+}
+
+type Identity struct {
+	Name     string   `json:"name"`     // 客户端证书的URI SAN、DNS SAN或CN
+	Services []string `json:"services"` // 允许上传的服务，*表示所有服务
+	Tenant   string   `json:"tenant"`   // 所属租户
+}
@@ -0,0 +1,9 @@
+package boltconfig
+
+const (
+	DataID = "BoltDB"
+)
+
+type Config struct {
+	Path string `json:"path"` // 数据库文件路径
+}
@@ -0,0 +1,10 @@
+package fsconfig
+
+const (
+	DataID = "FileSystem"
+)
+
+type Config struct {
+	RootDir    string `json:"root_dir"`    // 存放profile文件的根目录
+	PathPrefix string `json:"path_prefix"` // 对象名前缀
+}
@@ -0,0 +1,16 @@
+package s3config
+
+const (
+	DataID = "S3"
+)
+
+type Config struct {
+	Endpoint        string `json:"endpoint"`          // 不带scheme的地址，比如 s3.amazonaws.com、127.0.0.1:9000
+	Region          string `json:"region"`            // 区域
+	AccessKeyID     string `json:"access_key_id"`     //
+	AccessKeySecret string `json:"access_key_secret"` //
+	Bucket          string `json:"bucket"`            // 桶名
+	PathPrefix      string `json:"path_prefix"`       // 对象名前缀
+	UseSSL          bool   `json:"use_ssl"`           // 是否使用https
+	PathStyle       bool   `json:"path_style"`        // 使用 endpoint/bucket 的路径风格访问，MinIO 一般需要打开
+}
@@ -0,0 +1,46 @@
+package scrapeconfig
+
+const (
+	DataID = "Scrape"
+)
+
+type Config struct {
+	Interval     int      `json:"interval"`      // 抓取间隔，单位秒，默认60
+	Timeout      int      `json:"timeout"`       // 单次抓取的超时，单位秒，默认10，不包含cpu profile的采集时长
+	CPUDuration  int      `json:"cpu_duration"`  // cpu profile的采集时长，单位秒，默认10
+	ProfileTypes []string `json:"profile_types"` // 抓取的profile类型，为空时抓取cpu、heap、allocs、goroutine
+	Targets      []Target `json:"targets"`       // 抓取的服务
+
+	FileSDConfigs  []FileSDConfig  `json:"file_sd_configs"` // 基于文件的服务发现
+	DNSSDConfigs   []DNSSDConfig   `json:"dns_sd_configs"`  // 基于DNS的服务发现
+	RelabelConfigs []RelabelConfig `json:"relabel_configs"` // 发现的服务的标签改写规则，按顺序执行
+}
+
+type Target struct {
+	Service        string            `json:"service"`         // 服务名
+	ServiceVersion string            `json:"service_version"` // 服务版本
+	Addr           string            `json:"addr"`            // net/http/pprof所在的地址，如http://10.0.0.1:6060
+	Tags           map[string]string `json:"tags"`            // 附加到profile的标签
+	Tenant         string            `json:"tenant"`          // 所属租户，为空时是默认租户
+}
+
+type FileSDConfig struct {
+	Files           []string `json:"files"`            // Prometheus file_sd格式的json或yaml文件，支持通配符
+	RefreshInterval int      `json:"refresh_interval"` // 重新读取的间隔，单位秒，默认30
+}
+
+type DNSSDConfig struct {
+	Names           []string `json:"names"`            // 查询的域名
+	Type            string   `json:"type"`             // SRV、A或AAAA，默认SRV
+	Port            int      `json:"port"`             // A和AAAA记录使用的端口
+	RefreshInterval int      `json:"refresh_interval"` // 重新查询的间隔，单位秒，默认30
+}
+
+type RelabelConfig struct {
+	SourceLabels []string `json:"source_labels"` // 取值的标签，用separator连接
+	Separator    string   `json:"separator"`     // 默认;
+	Regex        string   `json:"regex"`         // 匹配的正则，默认(.*)
+	TargetLabel  string   `json:"target_label"`  // replace写入的标签
+	Replacement  string   `json:"replacement"`   // 默认$1
+	Action       string   `json:"action"`        // replace、keep、drop、labelmap、labeldrop、labelkeep，默认replace
+}
@@ -7,6 +7,13 @@ const (
 type Config struct {
 	Service         string `json:"service"`          // 服务名
 	Addr            string `json:"addr"`             // http服务器地址
+	GRPCAddr        string `json:"grpc_addr"`        // gRPC服务器地址，为空时不启动
 	ShutdownTimeout int    `json:"shutdown_timeout"` // http graceful shutdown的最大等待时间
 	LogLevel        string `json:"log_level"`        // 日志打印级别
+
+	// TLS同时用于http和gRPC服务器，证书文件更新后自动重新加载
+	CertFile          string `json:"cert_file"`           // 证书文件，为空时不启用TLS
+	KeyFile           string `json:"key_file"`            // 私钥文件
+	ClientCAFile      string `json:"client_ca_file"`      // 校验客户端证书的CA，设置后启用mTLS
+	RequireClientCert bool   `json:"require_client_cert"` // 是否拒绝没有客户端证书的请求
 }
@@ -0,0 +1,92 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	aliacm "github.com/xiaojiaoyu100/aliyun-acm/v2"
+	"github.com/xiaojiaoyu100/aliyun-acm/v2/config"
+	"github.com/xiaojiaoyu100/aliyun-acm/v2/info"
+	"github.com/xiaojiaoyu100/aliyun-acm/v2/observer"
+)
+
+// acmDeliveryTimeout is how long Start waits for the handlers of the loaded configs.
+const acmDeliveryTimeout = time.Second * 30
+
+// ACM gets configs in json from Aliyun ACM, each data id is a config of the group.
+type ACM struct {
+	client    *aliacm.Diamond
+	group     string
+	observers []*acmObserver
+}
+
+// acmObserver is closed once its handler is called for the first time.
+type acmObserver struct {
+	o         *observer.Observer
+	once      sync.Once
+	delivered chan struct{}
+}
+
+func NewACM(client *aliacm.Diamond, group string) *ACM {
+	return &ACM{
+		client: client,
+		group:  group,
+	}
+}
+
+func (s *ACM) Register(dataID string, h Handler) error {
+	i := info.Info{Group: s.group, DataID: dataID}
+	ao := &acmObserver{delivered: make(chan struct{})}
+	o, err := observer.New(
+		observer.WithInfo(i),
+		observer.WithHandler(func(coll map[info.Info]*config.Config) {
+			defer ao.once.Do(func() {
+				close(ao.delivered)
+			})
+			cc, ok := coll[i]
+			if !ok || len(cc.Content) == 0 {
+				return
+			}
+			h(func(v interface{}) error {
+				return json.Unmarshal(cc.Content, v)
+			})
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("observer new error:info:%+v err:%w", i, err)
+	}
+	ao.o = o
+	s.observers = append(s.observers, ao)
+	s.client.Register(o)
+	return nil
+}
+
+// Start returns once the handlers of the loaded configs are called,
+// NotifyAll calls them in background.
+func (s *ACM) Start() error {
+	s.client.NotifyAll()
+	timeout := time.NewTimer(acmDeliveryTimeout)
+	defer timeout.Stop()
+	for _, ao := range s.observers {
+		if !ao.o.Ready() {
+			continue
+		}
+		select {
+		case <-ao.delivered:
+		case <-timeout.C:
+			return errors.New("timeout waiting for the configs to be handled")
+		}
+	}
+	return nil
+}
+
+func (s *ACM) SetHook(h func(err error)) {
+	s.client.SetHook(h)
+}
+
+func (s *ACM) Close() error {
+	return nil
+}
@@ -0,0 +1,143 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Env gets configs from environment variables. A config field is read from
+// PREFIX_DATAID_FIELD where FIELD is the upper cased json tag, e.g.
+// PROFILER_SERVER_ADDR sets the addr of the Server config. PREFIX_DATAID may
+// also hold the whole config in json, the field variables override it.
+// Environment variables never change, so Env does not watch.
+type Env struct {
+	prefix string
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+}
+
+func NewEnv(prefix string) *Env {
+	return &Env{
+		prefix:   prefix,
+		handlers: make(map[string]Handler),
+	}
+}
+
+func (s *Env) Register(dataID string, h Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.handlers[dataID]; ok {
+		return fmt.Errorf("handler of %s is already registered", dataID)
+	}
+	s.handlers[dataID] = h
+	return nil
+}
+
+func (s *Env) key(dataID string) string {
+	return strings.ToUpper(s.prefix + "_" + dataID)
+}
+
+// exists reports whether any variable of the config is set.
+func (s *Env) exists(key string) bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, key+"=") || strings.HasPrefix(kv, key+"_") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Env) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dataID, h := range s.handlers {
+		key := s.key(dataID)
+		if !s.exists(key) {
+			continue
+		}
+		h(func(v interface{}) error {
+			return decodeEnv(key, v)
+		})
+	}
+	return nil
+}
+
+func (s *Env) SetHook(h func(err error)) {}
+
+func (s *Env) Close() error {
+	return nil
+}
+
+func decodeEnv(key string, v interface{}) error {
+	if content, ok := os.LookupEnv(key); ok {
+		if err := json.Unmarshal([]byte(content), v); err != nil {
+			return fmt.Errorf("fail to unmarshal %s: %w", key, err)
+		}
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("config must be a pointer to struct")
+	}
+	ve := rv.Elem()
+	te := ve.Type()
+	for i := 0; i < te.NumField(); i++ {
+		name := strings.Split(te.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" || !ve.Field(i).CanSet() {
+			continue
+		}
+		if name == "" {
+			name = te.Field(i).Name
+		}
+		fieldKey := key + "_" + strings.ToUpper(name)
+		value, ok := os.LookupEnv(fieldKey)
+		if !ok {
+			continue
+		}
+		if err := setField(ve.Field(i), value); err != nil {
+			return fmt.Errorf("fail to parse %s: %w", fieldKey, err)
+		}
+	}
+	return nil
+}
+
+func setField(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		// Slices, maps and structs are given in json.
+		return json.Unmarshal([]byte(value), v.Addr().Interface())
+	}
+	return nil
+}
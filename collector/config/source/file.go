@@ -0,0 +1,224 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v2"
+)
+
+const reloadDelay = time.Millisecond * 100
+
+// File gets configs from a yaml or json file whose top level keys are
+// the data ids, e.g.
+//
+//	Server:
+//	  addr: ":8080"
+//	FileSystem:
+//	  root_dir: /var/lib/profiler
+//
+// The file is watched and the handlers of the changed configs are called again.
+type File struct {
+	path string
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	contents map[string][]byte
+	hook     func(err error)
+
+	watcher *fsnotify.Watcher
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewFile(path string) *File {
+	return &File{
+		path:     filepath.Clean(path),
+		handlers: make(map[string]Handler),
+		contents: make(map[string][]byte),
+		hook:     func(err error) {},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+func (s *File) Register(dataID string, h Handler) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.handlers[dataID]; ok {
+		return fmt.Errorf("handler of %s is already registered", dataID)
+	}
+	s.handlers[dataID] = h
+	return nil
+}
+
+func (s *File) SetHook(h func(err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = h
+}
+
+func (s *File) Start() error {
+	if err := s.reload(); err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("fail to create a file watcher: %w", err)
+	}
+	// Watch the dir instead of the file, editors and kubernetes config maps
+	// replace the file rather than write to it.
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("fail to watch %s: %w", s.path, err)
+	}
+	s.watcher = watcher
+	go s.watch()
+	return nil
+}
+
+func (s *File) watch() {
+	defer close(s.done)
+
+	ti := time.NewTimer(reloadDelay)
+	if !ti.Stop() {
+		<-ti.C
+	}
+	for {
+		select {
+		case <-s.stop:
+			ti.Stop()
+			return
+		case _, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			// A save usually comes with several events, wait for them to settle.
+			ti.Reset(reloadDelay)
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			s.onError(err)
+		case <-ti.C:
+			if err := s.reload(); err != nil {
+				s.onError(err)
+			}
+		}
+	}
+}
+
+func (s *File) onError(err error) {
+	s.mu.Lock()
+	hook := s.hook
+	s.mu.Unlock()
+	hook(err)
+}
+
+// reload reads the file and calls the handlers whose config changed.
+func (s *File) reload() error {
+	contents, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	var changed []func()
+	for dataID, h := range s.handlers {
+		content, ok := contents[dataID]
+		if !ok {
+			continue
+		}
+		if old, ok := s.contents[dataID]; ok && bytes.Equal(old, content) {
+			continue
+		}
+		h, content := h, content
+		changed = append(changed, func() {
+			h(jsonDecoder(content))
+		})
+	}
+	s.contents = contents
+	s.mu.Unlock()
+
+	for _, f := range changed {
+		f()
+	}
+	return nil
+}
+
+// read returns the json content of every config in the file.
+func (s *File) read() (map[string][]byte, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read config file: %w", err)
+	}
+	doc := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(s.path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".json":
+		err = json.Unmarshal(b, &doc)
+	default:
+		return nil, errors.New("config file must be .yaml, .yml or .json")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse config file: %w", err)
+	}
+	contents := make(map[string][]byte, len(doc))
+	for dataID, v := range doc {
+		content, err := json.Marshal(normalize(v))
+		if err != nil {
+			return nil, fmt.Errorf("fail to convert config %s: %w", dataID, err)
+		}
+		contents[dataID] = content
+	}
+	return contents, nil
+}
+
+func (s *File) Close() error {
+	if s.watcher == nil {
+		return nil
+	}
+	close(s.stop)
+	<-s.done
+	return s.watcher.Close()
+}
+
+// normalize turns the map[interface{}]interface{} decoded by yaml
+// into map[string]interface{} which can be encoded to json.
+func normalize(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, e := range vv {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case map[string]interface{}:
+		for k, e := range vv {
+			vv[k] = normalize(e)
+		}
+		return vv
+	case []interface{}:
+		for i, e := range vv {
+			vv[i] = normalize(e)
+		}
+		return vv
+	default:
+		return v
+	}
+}
+
+func jsonDecoder(content []byte) Decoder {
+	return func(v interface{}) error {
+		return json.Unmarshal(content, v)
+	}
+}
@@ -0,0 +1,21 @@
+package source
+
+// Decoder decodes a config into v, v is a pointer to a config struct
+// whose fields are described by json tags.
+type Decoder func(v interface{}) error
+
+// Handler is called with the config of a data id once it is loaded
+// and every time it changes, it is never called for a missing config.
+type Handler func(decode Decoder)
+
+// Source is where the collector gets its configs from.
+type Source interface {
+	// Register must be called before Start.
+	Register(dataID string, h Handler) error
+	// Start loads every registered config and keeps watching their changes,
+	// the handlers of the loaded configs are called before it returns.
+	Start() error
+	// SetHook sets a function receiving errors happened in background.
+	SetHook(h func(err error))
+	Close() error
+}
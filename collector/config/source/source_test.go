@@ -0,0 +1,111 @@
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Addr            string   `json:"addr"`
+	ShutdownTimeout int      `json:"shutdown_timeout"`
+	UseSSL          bool     `json:"use_ssl"`
+	Services        []string `json:"services"`
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "collector.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Server:\n  addr: \":8080\"\n  shutdown_timeout: 3\nOSS:\n  bucket: b\n")
+
+	s := NewFile(path)
+	defer s.Close()
+	var errs = make(chan error, 1)
+	s.SetHook(func(err error) {
+		errs <- err
+	})
+	configs := make(chan *testConfig, 4)
+	if err := s.Register("Server", func(decode Decoder) {
+		c := &testConfig{}
+		if err := decode(c); err != nil {
+			t.Error(err)
+		}
+		configs <- c
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	c := <-configs
+	if c.Addr != ":8080" || c.ShutdownTimeout != 3 {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+
+	write("Server:\n  addr: \":9090\"\n  shutdown_timeout: 3\nOSS:\n  bucket: b\n")
+	select {
+	case c := <-configs:
+		if c.Addr != ":9090" {
+			t.Fatalf("unexpected config: %+v", c)
+		}
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("config is not reloaded")
+	}
+
+	// Configs other than Server change, the Server handler is not called.
+	write("Server:\n  addr: \":9090\"\n  shutdown_timeout: 3\nOSS:\n  bucket: c\n")
+	select {
+	case c := <-configs:
+		t.Fatalf("unexpected reload: %+v", c)
+	case <-time.After(reloadDelay * 5):
+	}
+}
+
+func TestEnv(t *testing.T) {
+	os.Setenv("TEST_SERVER", `{"addr":":8080","services":["a"]}`)
+	os.Setenv("TEST_SERVER_SHUTDOWN_TIMEOUT", "3")
+	os.Setenv("TEST_SERVER_USE_SSL", "true")
+	os.Setenv("TEST_SERVER_SERVICES", `["a","b"]`)
+	defer func() {
+		os.Unsetenv("TEST_SERVER")
+		os.Unsetenv("TEST_SERVER_SHUTDOWN_TIMEOUT")
+		os.Unsetenv("TEST_SERVER_USE_SSL")
+		os.Unsetenv("TEST_SERVER_SERVICES")
+	}()
+
+	s := NewEnv("TEST")
+	var c *testConfig
+	if err := s.Register("Server", func(decode Decoder) {
+		c = &testConfig{}
+		if err := decode(c); err != nil {
+			t.Error(err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register("OSS", func(decode Decoder) {
+		t.Error("missing config is handled")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.Addr != ":8080" || c.ShutdownTimeout != 3 || !c.UseSSL || len(c.Services) != 2 {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+}
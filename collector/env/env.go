@@ -5,37 +5,25 @@ import (
 
 	"go.uber.org/zap"
 
-	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore"
-
-	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
 )
 
 type InfluxDBClient struct {
 	client *influxdb2.Client
 }
 
-type OSSClient struct {
-	EndPoint   string
-	Bucket     string
-	PathPrefix string
-	Client     *oss.Client
-}
-
-type TablestoreClient struct {
-	TableName string
-	Client    *tablestore.TableStoreClient
-}
-
 type Logger struct {
 	*zap.Logger
 }
 
 type Env struct {
-	logger           *Logger
-	ossClient        *OSSClient
-	tableStoreClient *TablestoreClient
-	influxClient     *InfluxDBClient
+	logger       *Logger
+	blobStore    storage.BlobStore
+	metaIndex    storage.MetaIndex
+	influxClient *InfluxDBClient
+	auth         *auth.Authenticator
 }
 
 var (
@@ -46,18 +34,12 @@ var (
 func Instance() *Env {
 	once.Do(func() {
 		env = &Env{
-			ossClient:        &OSSClient{},
-			influxClient:     &InfluxDBClient{},
-			tableStoreClient: &TablestoreClient{},
+			influxClient: &InfluxDBClient{},
 		}
 	})
 	return env
 }
 
-func (e *Env) SetOSSClient(client *OSSClient) {
-	e.ossClient = client
-}
-
 func (e *Env) SetLogger(logger *Logger) {
 	e.logger = logger
 }
@@ -66,8 +48,20 @@ func (e *Env) Logger() *Logger {
 	return e.logger
 }
 
-func (e *Env) OSSClient() *OSSClient {
-	return e.ossClient
+func (e *Env) SetBlobStore(store storage.BlobStore) {
+	e.blobStore = store
+}
+
+func (e *Env) BlobStore() storage.BlobStore {
+	return e.blobStore
+}
+
+func (e *Env) SetMetaIndex(index storage.MetaIndex) {
+	e.metaIndex = index
+}
+
+func (e *Env) MetaIndex() storage.MetaIndex {
+	return e.metaIndex
 }
 
 func (e *Env) SetInfluxDBClient(client *influxdb2.Client) {
@@ -78,10 +72,11 @@ func (e *Env) InfluxDBClient() *influxdb2.Client {
 	return e.influxClient.client
 }
 
-func (e *Env) SetTablestoreClient(client *TablestoreClient) {
-	e.tableStoreClient = client
+// SetAuthenticator sets the authenticator of the uploads, nil turns the authentication off.
+func (e *Env) SetAuthenticator(a *auth.Authenticator) {
+	e.auth = a
 }
 
-func (e *Env) TablestoreClient() *TablestoreClient {
-	return e.tableStoreClient
+func (e *Env) Authenticator() *auth.Authenticator {
+	return e.auth
 }
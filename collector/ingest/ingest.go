@@ -0,0 +1,112 @@
+// Package ingest stores the profiles uploaded by the agents,
+// it is shared by every upload protocol.
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+	"github.com/xiaojiaoyu100/profiler/profile"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Meta is the metadata an agent uploads along with a profile.
+type Meta struct {
+	// Tenant comes from the credential of the upload, never from the agent.
+	Tenant         string            `json:"-"`
+	Service        string            `json:"service"`
+	ServiceVersion string            `json:"service_version"`
+	Host           string            `json:"host"`
+	IP             string            `json:"ip"`
+	GoVersion      string            `json:"go_version"`
+	ProfileType    string            `json:"profile_type"`
+	SendTime       int64             `json:"send_time"`
+	CreateTime     int64             `json:"create_time"`
+	Delta          bool              `json:"delta"` // profile是否是两次快照的差值
+	Tags           map[string]string `json:"tags"`
+	Triggered      bool              `json:"triggered"`      // 是否是指标超过阈值时触发采集的
+	TriggerReason  string            `json:"trigger_reason"` // 触发原因
+	// 采集时生效的采样率
+	BlockProfileRate     int64 `json:"block_profile_rate"`
+	MutexProfileFraction int64 `json:"mutex_profile_fraction"`
+	MemProfileRate       int64 `json:"mem_profile_rate"`
+}
+
+// servicePattern keeps the service a single path segment of the object name.
+var servicePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Validate checks the fields the object name is built from.
+func (m *Meta) Validate() error {
+	if !servicePattern.MatchString(m.Service) {
+		return fmt.Errorf("invalid service: %q", m.Service)
+	}
+	if profile.ParseType(m.ProfileType) == profile.TypeUnknown {
+		return fmt.Errorf("invalid profile type: %q", m.ProfileType)
+	}
+	return nil
+}
+
+var cn = time.FixedZone("GMT", 8*3600)
+
+// TenantPathPrefix is the path prefix of the objects of a tenant,
+// the default tenant keeps the path prefix of the blob store.
+func TenantPathPrefix(pathPrefix, tenant string) string {
+	if tenant == "" {
+		return pathPrefix
+	}
+	return fmt.Sprintf("%s/tenants/%s", pathPrefix, tenant)
+}
+
+func UploadPath(pathPrefix, service, profileType, fileName string) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s",
+		pathPrefix,
+		time.Now().In(cn).Format("2006-01-02"),
+		service,
+		profileType,
+		fileName)
+}
+
+// Ingest uploads the profile data and indexes its metadata,
+// it returns the id of the new profile.
+func Ingest(blobStore storage.BlobStore, metaIndex storage.MetaIndex, meta *Meta, data []byte) (string, error) {
+	if err := meta.Validate(); err != nil {
+		return "", err
+	}
+	profileID := primitive.NewObjectID().Hex()
+	objectName := UploadPath(TenantPathPrefix(blobStore.PathPrefix(), meta.Tenant), meta.Service, meta.ProfileType, profileID)
+
+	if err := blobStore.Put(objectName, bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("fail to upload: %w", err)
+	}
+
+	err := metaIndex.Insert(&profilemodel.Model{
+		ProfileId:      profileID,
+		Tenant:         meta.Tenant,
+		Service:        meta.Service,
+		ServiceVersion: meta.ServiceVersion,
+		Host:           meta.Host,
+		IP:             meta.IP,
+		GoVersion:      meta.GoVersion,
+		ProfileType:    meta.ProfileType,
+		SendTime:       meta.SendTime,
+		CreateTime:     meta.CreateTime,
+		ObjectName:     objectName,
+		Size:           int64(len(data)),
+		Tags:           meta.Tags,
+		Delta:          meta.Delta,
+		Triggered:      meta.Triggered,
+		TriggerReason:  meta.TriggerReason,
+
+		BlockProfileRate:     meta.BlockProfileRate,
+		MutexProfileFraction: meta.MutexProfileFraction,
+		MemProfileRate:       meta.MemProfileRate,
+	})
+	if err != nil {
+		return "", fmt.Errorf("fail to insert a row: %w", err)
+	}
+	return profileID, nil
+}
@@ -0,0 +1,37 @@
+package ingest
+
+import (
+	"testing"
+)
+
+func TestUploadPath(t *testing.T) {
+	t.Logf(UploadPath("abc", "bcf", "cpu", "efg"))
+}
+
+func TestTenantPathPrefix(t *testing.T) {
+	if p := TenantPathPrefix("profiles", ""); p != "profiles" {
+		t.Errorf("default tenant prefix = %s", p)
+	}
+	if p := TenantPathPrefix("profiles", "team-a"); p != "profiles/tenants/team-a" {
+		t.Errorf("tenant prefix = %s", p)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, m := range []Meta{
+		{Service: "../../tenants/team-b", ProfileType: "cpu"},
+		{Service: "gateway/..", ProfileType: "cpu"},
+		{Service: "..", ProfileType: "cpu"},
+		{Service: "", ProfileType: "cpu"},
+		{Service: "gateway", ProfileType: "../cpu"},
+		{Service: "gateway", ProfileType: "unknown"},
+	} {
+		if err := m.Validate(); err == nil {
+			t.Errorf("service %q, profile type %q is valid", m.Service, m.ProfileType)
+		}
+	}
+	m := Meta{Service: "gateway.api_v2-hz", ProfileType: "threadcreate"}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,4 @@
+// Package ingestpb is the gRPC api of ingesting profiles.
+package ingestpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ingest.proto
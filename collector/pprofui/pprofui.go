@@ -0,0 +1,219 @@
+// Package pprofui serves the web UI of go tool pprof, the graph, flame graph,
+// source and peek views, for profiles kept by the collector.
+package pprofui
+
+import (
+	"container/list"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/pprof/driver"
+	gprofile "github.com/google/pprof/profile"
+)
+
+// Loader loads the profile to show.
+type Loader func() (*gprofile.Profile, error)
+
+type entry struct {
+	key      string
+	handlers map[string]http.Handler
+}
+
+// call is a web UI being created, the requests of the same profile wait for it.
+type call struct {
+	done     chan struct{}
+	handlers map[string]http.Handler
+	err      error
+}
+
+// Server keeps the web UIs of the recently viewed profiles,
+// creating a web UI analyzes the whole profile.
+type Server struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	cache   map[string]*list.Element
+	loading map[string]*call
+}
+
+func New(size int) *Server {
+	return &Server{
+		size:    size,
+		order:   list.New(),
+		cache:   make(map[string]*list.Element),
+		loading: make(map[string]*call),
+	}
+}
+
+// ServeHTTP serves the page at path of the web UI of the profile identified by key,
+// path is the part of the url after the prefix the web UI is mounted at.
+// The error of load is returned as is.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request, key, path string, load Loader) error {
+	handlers, err := s.handlers(key, load)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		path = "/"
+	}
+	h, ok := handlers[path]
+	if !ok {
+		http.NotFound(w, r)
+		return nil
+	}
+	h.ServeHTTP(w, r)
+	return nil
+}
+
+// handlers returns the cached web UI of key or creates it, the lock is not held
+// while loading, and the concurrent requests of key share one load.
+func (s *Server) handlers(key string, load Loader) (map[string]http.Handler, error) {
+	s.mu.Lock()
+	if e, ok := s.cache[key]; ok {
+		s.order.MoveToFront(e)
+		s.mu.Unlock()
+		return e.Value.(*entry).handlers, nil
+	}
+	if c, ok := s.loading[key]; ok {
+		s.mu.Unlock()
+		<-c.done
+		return c.handlers, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	s.loading[key] = c
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.loading, key)
+		if c.err == nil {
+			s.add(key, c.handlers)
+		}
+		s.mu.Unlock()
+		close(c.done)
+	}()
+	p, err := load()
+	if err != nil {
+		c.err = err
+		return nil, err
+	}
+	c.handlers, c.err = newHandlers(key, p)
+	return c.handlers, c.err
+}
+
+// add caches the web UI of key and evicts the least recently viewed ones,
+// the caller must hold mu.
+func (s *Server) add(key string, handlers map[string]http.Handler) {
+	s.cache[key] = s.order.PushFront(&entry{key: key, handlers: handlers})
+	for s.order.Len() > s.size {
+		e := s.order.Back()
+		s.order.Remove(e)
+		delete(s.cache, e.Value.(*entry).key)
+	}
+}
+
+// newHandlers runs the pprof driver in web mode and takes its handlers
+// instead of letting it listen.
+func newHandlers(name string, p *gprofile.Profile) (map[string]http.Handler, error) {
+	var handlers map[string]http.Handler
+	err := driver.PProf(&driver.Options{
+		Flagset: &flags{
+			// The port is never listened, it only skips picking a random one.
+			args: []string{"-http=localhost:1", "-no_browser", "-symbolize=none", name},
+		},
+		Fetch: fetcher{p: p},
+		UI:    ui{},
+		HTTPServer: func(args *driver.HTTPServerArgs) error {
+			handlers = args.Handlers
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fail to create the pprof web ui: %w", err)
+	}
+	if handlers == nil {
+		return nil, errors.New("pprof web ui has no handlers")
+	}
+	return handlers, nil
+}
+
+// flags feeds the pprof driver with fixed command line arguments.
+type flags struct {
+	args  []string
+	fs    flag.FlagSet
+	usage []string
+}
+
+func (f *flags) Bool(name string, def bool, usage string) *bool {
+	return f.fs.Bool(name, def, usage)
+}
+
+func (f *flags) Int(name string, def int, usage string) *int {
+	return f.fs.Int(name, def, usage)
+}
+
+func (f *flags) Float64(name string, def float64, usage string) *float64 {
+	return f.fs.Float64(name, def, usage)
+}
+
+func (f *flags) String(name string, def string, usage string) *string {
+	return f.fs.String(name, def, usage)
+}
+
+func (f *flags) StringList(name string, def string, usage string) *[]*string {
+	return &[]*string{f.fs.String(name, def, usage)}
+}
+
+func (f *flags) ExtraUsage() string {
+	return strings.Join(f.usage, "\n")
+}
+
+func (f *flags) AddExtraUsage(eu string) {
+	f.usage = append(f.usage, eu)
+}
+
+func (f *flags) Parse(usage func()) []string {
+	f.fs.Usage = usage
+	f.fs.SetOutput(io.Discard)
+	if err := f.fs.Parse(f.args); err != nil {
+		return nil
+	}
+	return f.fs.Args()
+}
+
+// fetcher hands the loaded profile to the pprof driver.
+type fetcher struct {
+	p *gprofile.Profile
+}
+
+func (f fetcher) Fetch(src string, duration, timeout time.Duration) (*gprofile.Profile, string, error) {
+	return f.p, src, nil
+}
+
+// ui drops the messages of the pprof driver, there is no terminal.
+type ui struct{}
+
+func (ui) ReadLine(prompt string) (string, error) {
+	return "", io.EOF
+}
+
+func (ui) Print(...interface{}) {}
+
+func (ui) PrintErr(...interface{}) {}
+
+func (ui) IsTerminal() bool {
+	return false
+}
+
+func (ui) WantBrowser() bool {
+	return false
+}
+
+func (ui) SetAutoComplete(complete func(string) string) {}
@@ -0,0 +1,129 @@
+package pprofui
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gprofile "github.com/google/pprof/profile"
+)
+
+// testProfile returns the goroutine profile of the test.
+func testProfile() *gprofile.Profile {
+	buf := new(bytes.Buffer)
+	if err := pprof.Lookup("goroutine").WriteTo(buf, 0); err != nil {
+		panic(err)
+	}
+	p, err := gprofile.Parse(buf)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// countingLoader counts how many times the profile is loaded.
+func countingLoader(n *int32) Loader {
+	return func() (*gprofile.Profile, error) {
+		atomic.AddInt32(n, 1)
+		return testProfile(), nil
+	}
+}
+
+func serve(t *testing.T, s *Server, key, path string, load Loader) (int, error) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/ui/profile/"+key+path, nil)
+	err := s.ServeHTTP(w, r, key, path, load)
+	return w.Code, err
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := New(2)
+	var a, b, c int32
+
+	if code, err := serve(t, s, "a", "/top", countingLoader(&a)); err != nil || code != http.StatusOK {
+		t.Fatalf("miss: code %d, err %v", code, err)
+	}
+	if code, err := serve(t, s, "a", "/flamegraph", countingLoader(&a)); err != nil || code != http.StatusOK {
+		t.Fatalf("hit: code %d, err %v", code, err)
+	}
+	if a != 1 {
+		t.Fatalf("a is loaded %d times, want 1", a)
+	}
+
+	if code, err := serve(t, s, "a", "/nothing", countingLoader(&a)); err != nil || code != http.StatusNotFound {
+		t.Fatalf("unknown page: code %d, err %v", code, err)
+	}
+
+	// b and c evict a, the least recently viewed.
+	serve(t, s, "b", "/top", countingLoader(&b))
+	serve(t, s, "c", "/top", countingLoader(&c))
+	serve(t, s, "a", "/top", countingLoader(&a))
+	if a != 2 || b != 1 || c != 1 {
+		t.Fatalf("loads a=%d b=%d c=%d, want 2, 1, 1", a, b, c)
+	}
+
+	errNotFound := errors.New("not found")
+	_, err := serve(t, s, "missing", "/top", func() (*gprofile.Profile, error) {
+		return nil, errNotFound
+	})
+	if !errors.Is(err, errNotFound) {
+		t.Fatalf("err %v, want %v", err, errNotFound)
+	}
+	var missing int32
+	serve(t, s, "missing", "/top", countingLoader(&missing))
+	if missing != 1 {
+		t.Fatal("a failed load is cached")
+	}
+}
+
+func TestServeHTTPConcurrentLoad(t *testing.T) {
+	s := New(2)
+	var cached int32
+	serve(t, s, "cached", "/top", countingLoader(&cached))
+
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+	var slow int32
+	slowLoader := func() (*gprofile.Profile, error) {
+		atomic.AddInt32(&slow, 1)
+		started <- struct{}{}
+		<-release
+		return testProfile(), nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code, err := serve(t, s, "slow", "/top", slowLoader); err != nil || code != http.StatusOK {
+				t.Errorf("slow: code %d, err %v", code, err)
+			}
+		}()
+	}
+
+	// A slow load does not hold up the other profiles.
+	<-started
+	done := make(chan struct{})
+	go func() {
+		serve(t, s, "cached", "/top", countingLoader(&cached))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("a cache hit waits for a slow load")
+	}
+
+	close(release)
+	wg.Wait()
+	if slow != 1 {
+		t.Fatalf("slow is loaded %d times, want 1", slow)
+	}
+}
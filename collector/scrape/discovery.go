@@ -0,0 +1,167 @@
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The labels set by the discovery, the ones starting with "__" are
+// dropped after relabeling, the others become tags.
+const (
+	LabelAddress        = "__address__"
+	LabelScheme         = "__scheme__"
+	LabelTenant         = "__tenant__"
+	LabelService        = "service"
+	LabelServiceVersion = "service_version"
+
+	labelFilePath     = "__meta_filepath"
+	labelDNSName      = "__meta_dns_name"
+	labelDNSSRVTarget = "__meta_dns_srv_record_target"
+	labelDNSSRVPort   = "__meta_dns_srv_record_port"
+)
+
+const defaultRefreshInterval = time.Second * 30
+
+// TargetGroup is a group of addresses sharing the same labels,
+// it is the format of the Prometheus file_sd files.
+type TargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+}
+
+// Discoverer finds the targets to scrape.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]*TargetGroup, error)
+}
+
+// Discovery runs a Discoverer every RefreshInterval.
+type Discovery struct {
+	Discoverer      Discoverer
+	RefreshInterval time.Duration
+}
+
+// FileDiscoverer reads the target groups from json or yaml files,
+// a file name may be a pattern of filepath.Match.
+type FileDiscoverer struct {
+	Files []string
+}
+
+func (d *FileDiscoverer) Discover(ctx context.Context) ([]*TargetGroup, error) {
+	var ret []*TargetGroup
+	for _, pattern := range d.Files {
+		names, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			groups, err := readTargetGroups(name)
+			if err != nil {
+				return nil, fmt.Errorf("fail to read %s: %w", name, err)
+			}
+			for _, g := range groups {
+				if g.Labels == nil {
+					g.Labels = make(map[string]string)
+				}
+				g.Labels[labelFilePath] = name
+			}
+			ret = append(ret, groups...)
+		}
+	}
+	return ret, nil
+}
+
+func readTargetGroups(name string) ([]*TargetGroup, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*TargetGroup
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(b, &groups)
+	default:
+		err = json.Unmarshal(b, &groups)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// DNSDiscoverer looks up the names, Type is SRV, A or AAAA.
+// The SRV records give the ports, Port is used for the A and AAAA records.
+type DNSDiscoverer struct {
+	Names    []string
+	Type     string
+	Port     int
+	Resolver *net.Resolver
+}
+
+func (d *DNSDiscoverer) resolver() *net.Resolver {
+	if d.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return d.Resolver
+}
+
+func (d *DNSDiscoverer) Discover(ctx context.Context) ([]*TargetGroup, error) {
+	var ret []*TargetGroup
+	for _, name := range d.Names {
+		g, err := d.lookup(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("fail to look up %s: %w", name, err)
+		}
+		ret = append(ret, g)
+	}
+	return ret, nil
+}
+
+func (d *DNSDiscoverer) lookup(ctx context.Context, name string) (*TargetGroup, error) {
+	g := &TargetGroup{
+		Labels: map[string]string{labelDNSName: name},
+	}
+	switch strings.ToUpper(d.Type) {
+	case "SRV":
+		_, records, err := d.resolver().LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			host := strings.TrimSuffix(r.Target, ".")
+			g.Targets = append(g.Targets, net.JoinHostPort(host, strconv.Itoa(int(r.Port))))
+		}
+		// A group shares the labels, so the record labels only make sense for one record.
+		if len(records) == 1 {
+			g.Labels[labelDNSSRVTarget] = strings.TrimSuffix(records[0].Target, ".")
+			g.Labels[labelDNSSRVPort] = strconv.Itoa(int(records[0].Port))
+		}
+	case "A", "AAAA":
+		if d.Port <= 0 {
+			return nil, errors.New("no port provided")
+		}
+		network := "ip4"
+		if strings.ToUpper(d.Type) == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := d.resolver().LookupIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			g.Targets = append(g.Targets, net.JoinHostPort(ip.String(), strconv.Itoa(d.Port)))
+		}
+	default:
+		return nil, fmt.Errorf("unknown dns record type: %q", d.Type)
+	}
+	return g, nil
+}
@@ -0,0 +1,80 @@
+package scrape
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaojiaoyu100/profiler/collector/env"
+)
+
+func TestFileDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "a.json"),
+		[]byte(`[{"targets":["10.0.0.1:6060","10.0.0.2:6060"],"labels":{"service":"gateway","region":"hz"}}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "b.yaml"),
+		[]byte("- targets: [\"10.0.1.1:6060\"]\n  labels:\n    app: billing\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(WithEnv(&env.Env{}), WithOption(&Option{
+		Targets: []Target{{Service: "static", Addr: "http://127.0.0.1:6060"}},
+		Discoveries: []Discovery{
+			{Discoverer: &FileDiscoverer{Files: []string{filepath.Join(dir, "*")}}},
+		},
+		RelabelRules: []*RelabelRule{
+			{SourceLabels: []string{"app"}, Regex: "(.+)", TargetLabel: LabelService},
+			{Regex: "app", Action: RelabelLabelDrop},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := s.option.Discoveries[0].Discoverer.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.groups[0] = groups
+
+	targets := make(map[string]Target)
+	for _, target := range s.targets() {
+		targets[target.Addr] = target
+	}
+	if len(targets) != 4 {
+		t.Fatalf("targets = %v", targets)
+	}
+	if target := targets["http://10.0.0.2:6060"]; target.Service != "gateway" || target.Tags["region"] != "hz" {
+		t.Errorf("unexpected target: %+v", target)
+	}
+	if target := targets["http://10.0.1.1:6060"]; target.Service != "billing" || len(target.Tags) != 0 {
+		t.Errorf("unexpected target: %+v", target)
+	}
+	if target := targets["http://127.0.0.1:6060"]; target.Service != "static" {
+		t.Errorf("unexpected target: %+v", target)
+	}
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	d := &DNSDiscoverer{Names: []string{"localhost"}, Type: "A", Port: 6060}
+	groups, err := d.Discover(context.Background())
+	if err != nil {
+		t.Skip(err)
+	}
+	if len(groups) != 1 || len(groups[0].Targets) == 0 || groups[0].Targets[0] != "127.0.0.1:6060" {
+		t.Errorf("groups = %+v", groups)
+	}
+	if _, err := (&DNSDiscoverer{Names: []string{"localhost"}, Type: "TXT"}).Discover(context.Background()); err == nil {
+		t.Error("no error for an unknown type")
+	}
+}
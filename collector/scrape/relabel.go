@@ -0,0 +1,164 @@
+package scrape
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+)
+
+type RelabelAction string
+
+const (
+	RelabelReplace   RelabelAction = "replace"
+	RelabelKeep      RelabelAction = "keep"
+	RelabelDrop      RelabelAction = "drop"
+	RelabelLabelMap  RelabelAction = "labelmap"
+	RelabelLabelDrop RelabelAction = "labeldrop"
+	RelabelLabelKeep RelabelAction = "labelkeep"
+)
+
+// RelabelRule rewrites the labels of a discovered target like the relabel_config
+// of Prometheus. The source labels are joined with Separator and matched against
+// Regex, which is anchored at both ends.
+type RelabelRule struct {
+	SourceLabels []string
+	Separator    string // 默认;
+	Regex        string // 默认(.*)
+	TargetLabel  string
+	Replacement  string // 默认$1
+	Action       RelabelAction
+
+	re *regexp.Regexp
+}
+
+// compile fills the defaults and checks the rule.
+func (r *RelabelRule) compile() error {
+	if r.Separator == "" {
+		r.Separator = ";"
+	}
+	if r.Regex == "" {
+		r.Regex = "(.*)"
+	}
+	if r.Replacement == "" {
+		r.Replacement = "$1"
+	}
+	if r.Action == "" {
+		r.Action = RelabelReplace
+	}
+	re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid relabel regex %q: %w", r.Regex, err)
+	}
+	r.re = re
+	switch r.Action {
+	case RelabelReplace:
+		if r.TargetLabel == "" {
+			return fmt.Errorf("no target label provided for %s", r.Action)
+		}
+	case RelabelKeep, RelabelDrop, RelabelLabelMap, RelabelLabelDrop, RelabelLabelKeep:
+	default:
+		return fmt.Errorf("unknown relabel action: %q", r.Action)
+	}
+	return nil
+}
+
+// relabel applies the rules to the labels in order, it returns false
+// if the target is dropped.
+func relabel(labels map[string]string, rules []*RelabelRule) (map[string]string, bool) {
+	ret := copyLabels(labels)
+	for _, r := range rules {
+		values := make([]string, 0, len(r.SourceLabels))
+		for _, l := range r.SourceLabels {
+			values = append(values, ret[l])
+		}
+		value := strings.Join(values, r.Separator)
+
+		switch r.Action {
+		case RelabelReplace:
+			m := r.re.FindStringSubmatchIndex(value)
+			if m == nil {
+				continue
+			}
+			target := string(r.re.ExpandString(nil, r.TargetLabel, value, m))
+			v := string(r.re.ExpandString(nil, r.Replacement, value, m))
+			if v == "" {
+				delete(ret, target)
+				continue
+			}
+			ret[target] = v
+		case RelabelKeep:
+			if !r.re.MatchString(value) {
+				return nil, false
+			}
+		case RelabelDrop:
+			if r.re.MatchString(value) {
+				return nil, false
+			}
+		case RelabelLabelMap:
+			// Iterate a copy as the new labels are added.
+			for k, v := range copyLabels(ret) {
+				if r.re.MatchString(k) {
+					ret[r.re.ReplaceAllString(k, r.Replacement)] = v
+				}
+			}
+		case RelabelLabelDrop:
+			for k := range ret {
+				if r.re.MatchString(k) {
+					delete(ret, k)
+				}
+			}
+		case RelabelLabelKeep:
+			for k := range ret {
+				if !r.re.MatchString(k) {
+					delete(ret, k)
+				}
+			}
+		}
+	}
+	return ret, true
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	ret := make(map[string]string, len(labels))
+	for k, v := range labels {
+		ret[k] = v
+	}
+	return ret
+}
+
+// targetFromLabels builds the target of the relabeled labels, the labels
+// other than service and service_version that do not start with "__" become tags.
+func targetFromLabels(labels map[string]string) (Target, error) {
+	t := Target{
+		Service:        labels[LabelService],
+		ServiceVersion: labels[LabelServiceVersion],
+		Tenant:         labels[LabelTenant],
+	}
+	if t.Service == "" {
+		return t, fmt.Errorf("no %s label of %s", LabelService, labels[LabelAddress])
+	}
+	addr := labels[LabelAddress]
+	if addr == "" {
+		return t, fmt.Errorf("no %s label of %s", LabelAddress, t.Service)
+	}
+	if !auth.ValidTenant(t.Tenant) {
+		return t, fmt.Errorf("invalid tenant of %s: %q", addr, t.Tenant)
+	}
+	scheme := labels[LabelScheme]
+	if scheme == "" {
+		scheme = "http"
+	}
+	t.Addr = scheme + "://" + addr
+	for k, v := range labels {
+		if strings.HasPrefix(k, "__") || k == LabelService || k == LabelServiceVersion {
+			continue
+		}
+		if t.Tags == nil {
+			t.Tags = make(map[string]string)
+		}
+		t.Tags[k] = v
+	}
+	return t, nil
+}
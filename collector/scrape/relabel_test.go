@@ -0,0 +1,56 @@
+package scrape
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRelabel(t *testing.T) {
+	rules := []*RelabelRule{
+		{SourceLabels: []string{"env"}, Regex: "dev", Action: RelabelDrop},
+		{SourceLabels: []string{"__meta_app"}, TargetLabel: LabelService},
+		{SourceLabels: []string{"__meta_app", "__meta_version"}, Regex: "(.+);v(.+)", TargetLabel: LabelServiceVersion, Replacement: "$2"},
+		{Regex: "__meta_k8s_(.+)", Action: RelabelLabelMap},
+		{SourceLabels: []string{LabelAddress}, Regex: "(.+):6060", TargetLabel: LabelAddress, Replacement: "$1:7070"},
+	}
+	for _, r := range rules {
+		if err := r.compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	labels, ok := relabel(map[string]string{
+		LabelAddress:      "10.0.0.1:6060",
+		"__meta_app":      "gateway",
+		"__meta_version":  "v1.2",
+		"__meta_k8s_zone": "hz-a",
+		"__meta_filepath": "a.json",
+		"env":             "prod",
+	}, rules)
+	if !ok {
+		t.Fatal("dropped")
+	}
+	target, err := targetFromLabels(labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Target{
+		Service:        "gateway",
+		ServiceVersion: "1.2",
+		Addr:           "http://10.0.0.1:7070",
+		Tags:           map[string]string{"zone": "hz-a", "env": "prod"},
+	}
+	if !reflect.DeepEqual(target, want) {
+		t.Errorf("target = %+v, want %+v", target, want)
+	}
+
+	if _, ok := relabel(map[string]string{"env": "dev"}, rules); ok {
+		t.Error("dev is not dropped")
+	}
+	if _, err := targetFromLabels(map[string]string{LabelAddress: "10.0.0.1:6060"}); err == nil {
+		t.Error("no error without service")
+	}
+	if err := (&RelabelRule{Action: "unknown"}).compile(); err == nil {
+		t.Error("no error for an unknown action")
+	}
+}
@@ -0,0 +1,365 @@
+// Package scrape pulls profiles from the /debug/pprof endpoints of
+// the services that do not embed the agent, see net/http/pprof.
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	gprofile "github.com/google/pprof/profile"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/env"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest"
+	"github.com/xiaojiaoyu100/profiler/profile"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultInterval    = time.Second * 60
+	defaultTimeout     = time.Second * 10
+	defaultCPUDuration = time.Second * 10
+)
+
+var defaultProfileTypes = []profile.Type{
+	profile.TypeCPU,
+	profile.TypeHeap,
+	profile.TypeAllocs,
+	profile.TypeGoroutine,
+}
+
+type Target struct {
+	Service        string
+	ServiceVersion string
+	// Addr is the base url of net/http/pprof, e.g. http://10.0.0.1:6060.
+	Addr   string
+	Tags   map[string]string
+	Tenant string
+}
+
+type Option struct {
+	Interval     time.Duration
+	Timeout      time.Duration
+	CPUDuration  time.Duration
+	ProfileTypes []profile.Type
+	// Targets are scraped as they are, the discovered targets are relabeled.
+	Targets      []Target
+	Discoveries  []Discovery
+	RelabelRules []*RelabelRule
+}
+
+type Setter func(s *Scraper) error
+
+// Scraper scrapes every target on its own goroutine and stores the profiles
+// through the same ingest path as the uploaded ones.
+type Scraper struct {
+	option *Option
+	env    *env.Env
+	logger *zap.Logger
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// groups keeps the latest target groups of every discovery.
+	groups [][]*TargetGroup
+	// loops cancels the scraping of a target by its key.
+	loops map[string]context.CancelFunc
+}
+
+func WithLogger(logger *zap.Logger) Setter {
+	return func(s *Scraper) error {
+		s.logger = logger
+		return nil
+	}
+}
+
+func WithEnv(e *env.Env) Setter {
+	return func(s *Scraper) error {
+		s.env = e
+		return nil
+	}
+}
+
+func WithOption(option *Option) Setter {
+	return func(s *Scraper) error {
+		s.option = option
+		return nil
+	}
+}
+
+func New(setters ...Setter) (*Scraper, error) {
+	s := &Scraper{
+		logger: zap.NewNop(),
+		client: &http.Client{},
+		loops:  make(map[string]context.CancelFunc),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, setter := range setters {
+		if err := setter(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.option == nil {
+		return nil, errors.New("no option provided")
+	}
+	if s.env == nil {
+		return nil, errors.New("no env provided")
+	}
+	if s.option.Interval <= 0 {
+		s.option.Interval = defaultInterval
+	}
+	if s.option.Timeout <= 0 {
+		s.option.Timeout = defaultTimeout
+	}
+	if s.option.CPUDuration <= 0 {
+		s.option.CPUDuration = defaultCPUDuration
+	}
+	if len(s.option.ProfileTypes) == 0 {
+		s.option.ProfileTypes = defaultProfileTypes
+	}
+	for _, t := range s.option.ProfileTypes {
+		if t == profile.TypeUnknown {
+			return nil, errors.New("unknown profile type")
+		}
+	}
+	for _, target := range s.option.Targets {
+		if target.Service == "" {
+			return nil, fmt.Errorf("no service provided for target %s", target.Addr)
+		}
+		if _, err := url.Parse(target.Addr); err != nil || target.Addr == "" {
+			return nil, fmt.Errorf("invalid addr of target %s: %q", target.Service, target.Addr)
+		}
+		if !auth.ValidTenant(target.Tenant) {
+			return nil, fmt.Errorf("invalid tenant of target %s: %q", target.Service, target.Tenant)
+		}
+	}
+	for _, d := range s.option.Discoveries {
+		if d.Discoverer == nil {
+			return nil, errors.New("no discoverer provided")
+		}
+	}
+	for _, r := range s.option.RelabelRules {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+	}
+	s.groups = make([][]*TargetGroup, len(s.option.Discoveries))
+	return s, nil
+}
+
+// Run starts scraping, the targets are spread over the first interval.
+func (s *Scraper) Run() {
+	s.sync()
+	for i, d := range s.option.Discoveries {
+		i, d := i, d
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.discover(i, d)
+		}()
+	}
+}
+
+// Close stops scraping and waits for the running scrapes.
+func (s *Scraper) Close() {
+	if s == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+// discover refreshes the target groups of the i-th discovery,
+// the previous groups are kept if it fails.
+func (s *Scraper) discover(i int, d Discovery) {
+	interval := d.RefreshInterval
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	ti := time.NewTimer(0)
+	defer ti.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ti.C:
+		}
+		groups, err := d.Discoverer.Discover(s.ctx)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				s.logger.Warn("fail to discover scrape targets", zap.Error(err))
+			}
+		} else {
+			s.mu.Lock()
+			s.groups[i] = groups
+			s.mu.Unlock()
+			s.sync()
+		}
+		ti.Reset(interval)
+	}
+}
+
+// targets returns the static targets and the relabeled discovered ones by key.
+func (s *Scraper) targets() map[string]Target {
+	ret := make(map[string]Target)
+	for _, t := range s.option.Targets {
+		ret[t.key()] = t
+	}
+	for _, groups := range s.groups {
+		for _, g := range groups {
+			for _, addr := range g.Targets {
+				labels := copyLabels(g.Labels)
+				labels[LabelAddress] = addr
+				labels, ok := relabel(labels, s.option.RelabelRules)
+				if !ok {
+					continue
+				}
+				t, err := targetFromLabels(labels)
+				if err != nil {
+					s.logger.Warn("drop a discovered scrape target", zap.Error(err))
+					continue
+				}
+				ret[t.key()] = t
+			}
+		}
+	}
+	return ret
+}
+
+// sync starts scraping the new targets and stops the gone ones.
+func (s *Scraper) sync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return
+	}
+	targets := s.targets()
+	for key, cancel := range s.loops {
+		if _, ok := targets[key]; !ok {
+			cancel()
+			delete(s.loops, key)
+		}
+	}
+	for key, target := range targets {
+		if _, ok := s.loops[key]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(s.ctx)
+		s.loops[key] = cancel
+		target := target
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, &target)
+		}()
+	}
+}
+
+// key identifies a target, a target whose labels change is a new one.
+func (t *Target) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%v", t.Tenant, t.Addr, t.Service, t.ServiceVersion, t.Tags)
+}
+
+func (s *Scraper) loop(ctx context.Context, target *Target) {
+	ti := time.NewTimer(time.Duration(rand.Int63n(int64(s.option.Interval))))
+	defer ti.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ti.C:
+		}
+		for _, t := range s.option.ProfileTypes {
+			if err := s.scrape(ctx, target, t); err != nil && ctx.Err() == nil {
+				s.logger.Warn(fmt.Sprintf("fail to scrape profile[%s]", t),
+					zap.String("service", target.Service),
+					zap.String("addr", target.Addr),
+					zap.Error(err))
+			}
+		}
+		ti.Reset(s.option.Interval)
+	}
+}
+
+// profileURL returns the url of the profile type under addr.
+func (s *Scraper) profileURL(addr string, t profile.Type) string {
+	addr = strings.TrimSuffix(addr, "/") + "/debug/pprof/"
+	switch t {
+	case profile.TypeCPU:
+		return addr + fmt.Sprintf("profile?seconds=%d", int(s.option.CPUDuration/time.Second))
+	case profile.TypeHeap:
+		// Like the agent, collect the garbage first so the heap is up to date.
+		return addr + "heap?gc=1"
+	default:
+		return addr + t.String()
+	}
+}
+
+// scrape fetches a profile from the target and ingests it.
+func (s *Scraper) scrape(ctx context.Context, target *Target, t profile.Type) error {
+	if s.env.BlobStore() == nil || s.env.MetaIndex() == nil {
+		return errors.New("no storage configured")
+	}
+	timeout := s.option.Timeout
+	if t == profile.TypeCPU {
+		timeout += s.option.CPUDuration
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var ip string
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+				ip = host
+			}
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.profileURL(target.Addr, t), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("response is not ok: %d %s", resp.StatusCode, data)
+	}
+	// Keep error pages and the like out of the storage.
+	if _, err := gprofile.ParseData(data); err != nil {
+		return fmt.Errorf("invalid profile: %w", err)
+	}
+
+	u, _ := url.Parse(target.Addr)
+	now := time.Now().Unix()
+	meta := &ingest.Meta{
+		Tenant:         target.Tenant,
+		Service:        target.Service,
+		ServiceVersion: target.ServiceVersion,
+		Host:           u.Hostname(),
+		IP:             ip,
+		ProfileType:    t.String(),
+		SendTime:       now,
+		CreateTime:     now,
+		Tags:           target.Tags,
+	}
+	_, err = ingest.Ingest(s.env.BlobStore(), s.env.MetaIndex(), meta, data)
+	return err
+}
@@ -0,0 +1,81 @@
+package scrape
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/collector/env"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/boltindex"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/fsblob"
+	"github.com/xiaojiaoyu100/profiler/profile"
+)
+
+func TestScrape(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scrape")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := fsblob.New(filepath.Join(dir, "blob"), "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := boltindex.New(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	e := &env.Env{}
+	e.SetBlobStore(store)
+	e.SetMetaIndex(index)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	target := Target{
+		Service:        "svc",
+		ServiceVersion: "v1",
+		Addr:           srv.URL,
+		Tags:           map[string]string{"region": "hz"},
+	}
+	s, err := New(WithEnv(e), WithOption(&Option{
+		CPUDuration: time.Second,
+		Targets:     []Target{target},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range s.option.ProfileTypes {
+		if err := s.scrape(context.Background(), &target, typ); err != nil {
+			t.Fatalf("scrape %s: %v", typ, err)
+		}
+	}
+	if err := s.scrape(context.Background(), &Target{Service: "svc", Addr: srv.URL + "/nothing"}, profile.TypeHeap); err == nil {
+		t.Error("no error for a missing endpoint")
+	}
+
+	list, total, err := index.Search(&storage.Query{Service: "svc", EndTime: time.Now().Unix() + 1}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != int64(len(defaultProfileTypes)) {
+		t.Fatalf("total = %d", total)
+	}
+	for _, m := range list {
+		if m.ServiceVersion != "v1" || m.IP != "127.0.0.1" || m.Host != "127.0.0.1" || m.Tags["region"] != "hz" {
+			t.Errorf("unexpected model: %+v", m)
+		}
+	}
+}
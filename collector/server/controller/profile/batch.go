@@ -0,0 +1,114 @@
+package profile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest"
+	"github.com/xiaojiaoyu100/profiler/collector/server/middleware"
+	"go.uber.org/zap"
+)
+
+const maxBatchSize = 100
+
+// batchResult is the result of one profile in a batch, Code is
+// the http status code the profile would get from /v1/profile.
+type batchResult struct {
+	ProfileID string `json:"profile_id,omitempty"`
+	Code      int    `json:"code"`
+	Error     string `json:"error,omitempty"`
+}
+
+type receiveProfileBatchDetail struct {
+	ResultList []batchResult `json:"result_list"`
+}
+
+func readFormFile(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// ReceiveProfileBatch receives several profiles in one multipart/form-data request,
+// the i-th "meta" field is the json encoded ingest.Meta of the i-th "profile" file,
+// which is the raw profile. The results are in the same order.
+func ReceiveProfileBatch(c *gin.Context) {
+	logger := middleware.Env(c).Logger
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid multipart form"})
+		return
+	}
+	metaList := form.Value["meta"]
+	fileList := form.File["profile"]
+	if len(metaList) != len(fileList) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "meta and profile mismatch"})
+		return
+	}
+	if len(metaList) > maxBatchSize {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "too many profiles"})
+		return
+	}
+
+	blobStore := middleware.Env(c).BlobStore()
+	metaIndex := middleware.Env(c).MetaIndex()
+	resp := receiveProfileBatchDetail{
+		ResultList: make([]batchResult, len(metaList)),
+	}
+	for i := range metaList {
+		result := &resp.ResultList[i]
+
+		var meta ingest.Meta
+		if err := json.Unmarshal([]byte(metaList[i]), &meta); err != nil {
+			result.Code = http.StatusBadRequest
+			result.Error = "invalid meta"
+			continue
+		}
+		if err := meta.Validate(); err != nil {
+			result.Code = http.StatusBadRequest
+			result.Error = err.Error()
+			continue
+		}
+		meta.IP = c.ClientIP()
+		meta.Tenant = auth.Tenant(c.Request.Context())
+		if !auth.AllowService(c.Request.Context(), meta.Service) {
+			result.Code = http.StatusForbidden
+			result.Error = "service not allowed"
+			continue
+		}
+
+		data, err := readFormFile(fileList[i])
+		if err != nil {
+			result.Code = http.StatusBadRequest
+			result.Error = "invalid profile"
+			continue
+		}
+		if len(data) == 0 {
+			result.Code = http.StatusOK
+			continue
+		}
+
+		profileID, err := ingest.Ingest(blobStore, metaIndex, &meta, data)
+		if err != nil {
+			logger().WithRequestId(c).Info("fail to ingest profile",
+				zap.String("service", meta.Service),
+				zap.String("service_version", meta.ServiceVersion),
+				zap.String("ip", meta.IP),
+				zap.Error(err))
+			result.Code = http.StatusInternalServerError
+			result.Error = "fail to ingest profile"
+			continue
+		}
+		result.ProfileID = profileID
+		result.Code = http.StatusOK
+	}
+	c.AbortWithStatusJSON(http.StatusOK, resp)
+}
@@ -0,0 +1,131 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+type batchPart struct {
+	meta    string
+	profile []byte
+}
+
+func newBatchRequest(t *testing.T, metaList []string, profileList [][]byte) *http.Request {
+	t.Helper()
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for _, meta := range metaList {
+		if err := mw.WriteField("meta", meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range profileList {
+		fw, err := mw.CreateFormFile("profile", "profile")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(p)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/v2/profile", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func newBatch(t *testing.T, parts []batchPart) *http.Request {
+	t.Helper()
+	var (
+		metaList    []string
+		profileList [][]byte
+	)
+	for _, p := range parts {
+		metaList = append(metaList, p.meta)
+		profileList = append(profileList, p.profile)
+	}
+	return newBatchRequest(t, metaList, profileList)
+}
+
+func TestReceiveProfileBatchInvalid(t *testing.T) {
+	engine := newTestEngine(newTestEnv(t))
+	meta := `{"service":"gateway","profile_type":"heap"}`
+	data := testProfileData(t)
+
+	tooMany := make([]batchPart, maxBatchSize+1)
+	for i := range tooMany {
+		tooMany[i] = batchPart{meta: meta, profile: data}
+	}
+	for name, r := range map[string]*http.Request{
+		"mismatch": newBatchRequest(t, []string{meta, meta}, [][]byte{data}),
+		"too many": newBatch(t, tooMany),
+		"not form": httptest.NewRequest(http.MethodPost, "/v2/profile", bytes.NewReader(data)),
+	} {
+		if w := doRequest(engine, r); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: code %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReceiveProfileBatch(t *testing.T) {
+	e := newTestEnv(t)
+	engine := newTestEngine(e)
+	data := testProfileData(t)
+
+	r := newBatch(t, []batchPart{
+		{meta: `{"service":"gateway","profile_type":"heap","create_time":1}`, profile: data},
+		{meta: `{"service":`, profile: data},
+		{meta: `{"service":"gateway","profile_type":"heap"}`, profile: nil},
+		{meta: `{"service":"gateway","profile_type":"cpu","create_time":2}`, profile: data},
+		{meta: `{"service":"../../tenants/team-b","profile_type":"heap","create_time":3}`, profile: data},
+		{meta: `{"service":"gateway","profile_type":"../heap","create_time":4}`, profile: data},
+	})
+	w := doRequest(engine, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("code %d: %s", w.Code, w.Body)
+	}
+	var resp receiveProfileBatchDetail
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{http.StatusOK, http.StatusBadRequest, http.StatusOK, http.StatusOK, http.StatusBadRequest, http.StatusBadRequest}
+	if len(resp.ResultList) != len(want) {
+		t.Fatalf("results: %+v", resp.ResultList)
+	}
+	for i, result := range resp.ResultList {
+		if result.Code != want[i] {
+			t.Errorf("result %d: code %d, want %d", i, result.Code, want[i])
+		}
+		// Only the non-empty profiles that are accepted are stored.
+		stored := i == 0 || i == 3
+		if stored != (result.ProfileID != "") {
+			t.Errorf("result %d: profile id %q", i, result.ProfileID)
+		}
+	}
+	if resp.ResultList[1].Error != "invalid meta" {
+		t.Errorf("invalid meta error: %q", resp.ResultList[1].Error)
+	}
+
+	_, total, err := e.MetaIndex().Search(&storage.Query{Service: "gateway", StartTime: 0, EndTime: 10}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Fatalf("%d profiles are stored, want 2", total)
+	}
+	for _, i := range []int{0, 3} {
+		m, err := e.MetaIndex().Get(resp.ResultList[i].ProfileID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Size != int64(len(data)) {
+			t.Fatalf("profile %d has size %d", i, m.Size)
+		}
+	}
+}
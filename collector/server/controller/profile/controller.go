@@ -6,33 +6,26 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"reflect"
 	"runtime"
-	"strconv"
+	"sync"
 	"time"
 
-	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore"
-	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore/search"
-	"github.com/cavaliercoder/grab"
 	"github.com/gin-gonic/gin"
 	gprofile "github.com/google/pprof/profile"
-	"github.com/xiaojiaoyu100/profiler/collector/env"
+	"github.com/xiaojiaoyu100/profiler/collector/analysis"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest"
 	"github.com/xiaojiaoyu100/profiler/collector/server/middleware"
 	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+	profiletype "github.com/xiaojiaoyu100/profiler/profile"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.uber.org/zap"
 )
 
 type ReceiveProfileReq struct {
-	Service        string `json:"service"`
-	ServiceVersion string `json:"service_version"`
-	Host           string `json:"host"`
-	IP             string `json:"ip"`
-	GoVersion      string `json:"go_version"`
-	ProfileType    string `json:"profile_type"`
-	Profile        string `json:"profile"`
-	SendTime       int64  `json:"send_time"`
-	CreateTime     int64  `json:"create_time"`
+	ingest.Meta
+	Profile string `json:"profile"` // base64编码的profile
 }
 
 var cn = time.FixedZone("GMT", 8*3600)
@@ -45,59 +38,29 @@ func ReceiveProfile(c *gin.Context) {
 		return
 	}
 	req.IP = c.ClientIP()
+	req.Tenant = auth.Tenant(c.Request.Context())
 
-	profileID := primitive.NewObjectID().Hex()
-
-	oss := middleware.Env(c).OSSClient()
-	bucket, err := oss.Client.Bucket(oss.Bucket)
-	if err != nil {
-		logger().WithRequestId(c).Info("new bucket err",
-			zap.String("service", req.Service),
-			zap.String("service_version", req.ServiceVersion),
-			zap.String("ip", req.IP),
-			zap.Error(err))
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
-	}
-
-	pf, err := base64.StdEncoding.DecodeString(req.Profile)
-	if err != nil {
-		logger().WithRequestId(c).Info("fail to decode profile",
+	if err := req.Meta.Validate(); err != nil {
+		logger().WithRequestId(c).Info("invalid meta",
 			zap.String("service", req.Service),
-			zap.String("service_version", req.ServiceVersion),
 			zap.String("ip", req.IP),
 			zap.Error(err))
-		c.AbortWithStatus(http.StatusInternalServerError)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	buf := bytes.NewBuffer(pf)
-	if buf.Len() == 0 {
-		logger().WithRequestId(c).Info("no profile provided",
+	if !auth.AllowService(c.Request.Context(), req.Service) {
+		logger().WithRequestId(c).Info("service not allowed by the api key",
 			zap.String("service", req.Service),
-			zap.String("service_version", req.ServiceVersion),
 			zap.String("ip", req.IP),
 		)
-		c.Status(http.StatusOK)
-		return
-	}
-
-	objectName := UploadPath(oss.PathPrefix, req.Service, req.ProfileType, profileID)
-
-	err = bucket.PutObject(objectName, buf)
-	if err != nil {
-		logger().WithRequestId(c).Info("fail to upload",
-			zap.String("service", req.Service),
-			zap.String("service_version", req.ServiceVersion),
-			zap.String("ip", req.IP),
-			zap.Error(err))
-		c.AbortWithStatus(http.StatusInternalServerError)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "service not allowed"})
 		return
 	}
 
-	meta, err := bucket.GetObjectDetailedMeta(objectName)
+	pf, err := base64.StdEncoding.DecodeString(req.Profile)
 	if err != nil {
-		logger().WithRequestId(c).Info("fail to query object",
+		logger().WithRequestId(c).Info("fail to decode profile",
 			zap.String("service", req.Service),
 			zap.String("service_version", req.ServiceVersion),
 			zap.String("ip", req.IP),
@@ -106,40 +69,19 @@ func ReceiveProfile(c *gin.Context) {
 		return
 	}
 
-	size, err := strconv.ParseInt(meta.Get("Content-Length"), 10, 64)
-	if err != nil {
-		logger().WithRequestId(c).Info("fail to parse int64",
+	if len(pf) == 0 {
+		logger().WithRequestId(c).Info("no profile provided",
 			zap.String("service", req.Service),
 			zap.String("service_version", req.ServiceVersion),
 			zap.String("ip", req.IP),
-			zap.Error(err))
-		c.AbortWithStatus(http.StatusInternalServerError)
+		)
+		c.Status(http.StatusOK)
 		return
 	}
 
-	tb := middleware.Env(c).TablestoreClient()
-
-	putRowRequest := new(tablestore.PutRowRequest)
-	putRowChange := new(tablestore.PutRowChange)
-	putRowChange.TableName = tb.TableName
-	putPk := new(tablestore.PrimaryKey)
-	putPk.AddPrimaryKeyColumn(profilemodel.ProfileId, profileID)
-	putRowChange.PrimaryKey = putPk
-	putRowChange.AddColumn(profilemodel.Service, req.Service)
-	putRowChange.AddColumn(profilemodel.ServiceVersion, req.ServiceVersion)
-	putRowChange.AddColumn(profilemodel.Host, req.Host)
-	putRowChange.AddColumn(profilemodel.IP, req.IP)
-	putRowChange.AddColumn(profilemodel.GoVersion, req.GoVersion)
-	putRowChange.AddColumn(profilemodel.ProfileType, req.ProfileType)
-	putRowChange.AddColumn(profilemodel.SendTime, req.SendTime)
-	putRowChange.AddColumn(profilemodel.CreateTime, req.CreateTime)
-	putRowChange.AddColumn(profilemodel.ObjectName, objectName)
-	putRowChange.AddColumn(profilemodel.Size, size)
-	putRowChange.SetCondition(tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST)
-	putRowRequest.PutRowChange = putRowChange
-	_, err = tb.Client.PutRow(putRowRequest)
+	_, err = ingest.Ingest(middleware.Env(c).BlobStore(), middleware.Env(c).MetaIndex(), &req.Meta, pf)
 	if err != nil {
-		logger().WithRequestId(c).Info("fail to insert a row",
+		logger().WithRequestId(c).Info("fail to ingest profile",
 			zap.String("service", req.Service),
 			zap.String("service_version", req.ServiceVersion),
 			zap.String("ip", req.IP),
@@ -149,25 +91,40 @@ func ReceiveProfile(c *gin.Context) {
 	}
 }
 
-func UploadPath(pathPrefix, service, profileType, fileName string) string {
-	return fmt.Sprintf("%s/%s/%s/%s/%s",
-		pathPrefix,
-		time.Now().In(cn).Format("2006-01-02"),
-		service,
-		profileType,
-		fileName)
+type MergeProfileReq struct {
+	Host           string `json:"host"`
+	Ip             string `json:"ip"`
+	Service        string `json:"service"`
+	ServiceVersion string `json:"service_version"`
+	ProfileType    string `json:"profile_type"`
+	StartTime      int64  `json:"start_time"`
+	EndTime        int64  `json:"end_time"`
+	Format         string `json:"format"`      // 返回格式：pprof(默认)、flamegraph、speedscope、folded、top
+	SampleType     string `json:"sample_type"` // flamegraph等格式使用的sample类型，比如alloc_space、inuse_space
+	Sort           string `json:"sort"`        // top格式的排序方式：flat(默认)、cum
+	TopN           int    `json:"top_n"`       // top格式返回的行数，默认全部
+	// 按tag过滤：一个值表示相等，多个值表示属于其中之一
+	Tags map[string][]string `json:"tags"`
+	// 按pprof label过滤样本，比如 {"endpoint": ["/checkout"]}，规则同tags
+	Labels  map[string][]string `json:"labels"`
+	GroupBy string              `json:"group_by"` // 按pprof label分组，返回每个label值的总量，设置后忽略format
 }
 
-type MergeProfileReq struct {
-	Host        string `json:"host"`
-	Ip          string `json:"ip"`
-	Service     string `json:"service"`
-	ProfileType string `json:"profile_type"`
-	StartTime   int64  `json:"start_time"`
-	EndTime     int64  `json:"end_time"`
+func (req *MergeProfileReq) query() *storage.Query {
+	return &storage.Query{
+		Service:        req.Service,
+		ServiceVersion: req.ServiceVersion,
+		Host:           req.Host,
+		IP:             req.Ip,
+		ProfileType:    req.ProfileType,
+		StartTime:      req.StartTime,
+		EndTime:        req.EndTime,
+		Tags:           req.Tags,
+	}
 }
 
 type mergeProfileDetail struct {
+	ProfileID    string `json:"profile_id"` // 可以在 /ui/profile/:profile_id/ 查看
 	Url          string `json:"url"`
 	ProfileCount int    `json:"profile_count"`
 }
@@ -179,136 +136,193 @@ func MergeProfile(c *gin.Context) {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
+	if !validFormat(req.Format) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown format"})
+		return
+	}
+	if req.Sort != "" && req.Sort != analysis.SortFlat && req.Sort != analysis.SortCum {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown sort"})
+		return
+	}
 
-	tb := middleware.Env(c).TablestoreClient()
-	ossClient := middleware.Env(c).OSSClient()
-
-	bucket, err := ossClient.Client.Bucket(ossClient.Bucket)
+	mergeProfile, profileCount, err := mergeProfileList(c, req)
 	if err != nil {
-		logger().WithRequestId(c).Info("new bucket err",
+		logger().WithRequestId(c).Info("merge profile err",
 			zap.Reflect("req", req),
 			zap.Error(err))
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	profileModelList, err := getProfileModelList(tb, req)
+	if req.GroupBy != "" {
+		respondGroup(c, req, mergeProfile, profileCount)
+		return
+	}
+
+	if req.Format != "" && req.Format != formatPprof {
+		respondFormat(c, req, mergeProfile, profileCount)
+		return
+	}
+
+	blobStore := middleware.Env(c).BlobStore()
+	profileID, objectName, err := saveProfile(blobStore, auth.Tenant(c.Request.Context()), mergeProfile)
 	if err != nil {
-		logger().WithRequestId(c).Info("list profile err",
+		logger().WithRequestId(c).Info("fail to upload",
 			zap.Reflect("req", req),
 			zap.Error(err))
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	var reqs []*grab.Request
-	for _, profileModel := range profileModelList {
-		url := downloadPath(ossClient.Bucket, ossClient.EndPoint, profileModel.ObjectName)
-		req, err := grab.NewRequest("", url)
-		if err != nil {
-			c.AbortWithStatus(http.StatusInternalServerError)
-			return
-		}
-		req.NoStore = true
-		reqs = append(reqs, req)
+	var resp = mergeProfileDetail{
+		ProfileID:    profileID,
+		Url:          blobStore.URL(objectName),
+		ProfileCount: profileCount,
 	}
+	c.AbortWithStatusJSON(http.StatusOK, resp)
+}
 
-	var profileList []*gprofile.Profile
-
-	responses := grab.NewClient().DoBatch(runtime.NumCPU()*2, reqs...)
-Loop:
-	for i := 0; i < len(reqs); i++ {
-		select {
-		case ossResp := <-responses:
-			if ossResp == nil {
-				break Loop
-			}
-			if ossResp.HTTPResponse == nil {
-				break Loop
-			}
-
-			func() {
-				b, err := ossResp.Bytes()
-				if err != nil {
-					logger().WithRequestId(c).Info("oss resp bytes",
-						zap.Reflect("req", req),
-						zap.Error(err))
-					c.AbortWithStatus(http.StatusInternalServerError)
-					return
-				}
+// mergeProfileList merges the profiles selected by req into one,
+// it returns the merged profile and how many profiles are merged.
+func mergeProfileList(c *gin.Context, req MergeProfileReq) (*gprofile.Profile, int, error) {
+	profileModelList, err := getProfileModelList(middleware.Env(c).MetaIndex(), auth.Tenant(c.Request.Context()), req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list profile err: %w", err)
+	}
+	profileModelList = mergeableProfileModelList(req.ProfileType, profileModelList)
 
-				r, err := ossResp.Open()
-				if err != nil {
-					logger().WithRequestId(c).Info("oss resp open",
-						zap.Reflect("req", req),
-						zap.Error(err))
-					return
-				}
-				defer r.Close()
-
-				pprofProfile, err := gprofile.ParseData(b)
-				if err != nil {
-					logger().WithRequestId(c).Info("profile parse err",
-						zap.Reflect("req", req),
-						zap.Error(err))
-					c.AbortWithStatus(http.StatusInternalServerError)
-					return
-				}
-				profileList = append(profileList, pprofProfile)
-			}()
-		}
+	profileList, err := getProfileList(middleware.Env(c).BlobStore(), profileModelList)
+	if err != nil {
+		return nil, 0, fmt.Errorf("get profile err: %w", err)
 	}
 
 	mergeProfile, err := gprofile.Merge(profileList)
 	if err != nil {
-		logger().WithRequestId(c).Info("profile merge err",
-			zap.Reflect("req", req),
-			zap.Error(err))
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+		return nil, 0, fmt.Errorf("profile merge err: %w", err)
 	}
+	analysis.FilterLabels(mergeProfile, req.Labels)
+	return mergeProfile, len(profileList), nil
+}
 
-	buf := new(bytes.Buffer)
-	err = mergeProfile.Write(buf)
-	if err != nil {
-		logger().WithRequestId(c).Info("profile write err",
-			zap.Reflect("req", req),
-			zap.Error(err))
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+// mergeableProfileModelList drops the snapshots of a cumulative profile type
+// that a merge would double count: the deltas are all kept, while only the latest
+// snapshot of a host is kept, and none at all if the host uploads deltas.
+func mergeableProfileModelList(profileType string, list []*profilemodel.Model) []*profilemodel.Model {
+	if !profiletype.ParseType(profileType).Cumulative() {
+		return list
+	}
+	key := func(m *profilemodel.Model) string {
+		return m.Service + "/" + m.Host
+	}
+	deltaHosts := make(map[string]bool)
+	latest := make(map[string]*profilemodel.Model)
+	for _, m := range list {
+		if m.Delta {
+			deltaHosts[key(m)] = true
+			continue
+		}
+		if l, ok := latest[key(m)]; !ok || m.CreateTime >= l.CreateTime {
+			latest[key(m)] = m
+		}
+	}
+	result := make([]*profilemodel.Model, 0, len(list))
+	for _, m := range list {
+		if m.Delta || (!deltaHosts[key(m)] && latest[key(m)] == m) {
+			result = append(result, m)
+		}
 	}
+	return result
+}
 
+// saveProfile uploads a profile made by the collector for a tenant, such as a merge result,
+// it returns the new profile id and the object name.
+func saveProfile(blobStore storage.BlobStore, tenant string, p *gprofile.Profile) (string, string, error) {
+	buf := new(bytes.Buffer)
+	if err := p.Write(buf); err != nil {
+		return "", "", fmt.Errorf("profile write err: %w", err)
+	}
 	newProfileID := primitive.NewObjectID().Hex()
-	objectName := UploadPath(ossClient.PathPrefix, req.Service, req.ProfileType, newProfileID)
-
-	err = bucket.PutObject(objectName, buf)
-	if err != nil {
-		logger().WithRequestId(c).Info("fail to upload",
-			zap.Reflect("req", req),
-			zap.Error(err))
-		c.AbortWithStatus(http.StatusInternalServerError)
-		return
+	objectName := ResultPath(ingest.TenantPathPrefix(blobStore.PathPrefix(), tenant), newProfileID)
+	if err := blobStore.Put(objectName, buf); err != nil {
+		return "", "", err
 	}
+	return newProfileID, objectName, nil
+}
 
-	var resp = mergeProfileDetail{
-		Url:          downloadPath(ossClient.Bucket, ossClient.EndPoint, objectName),
-		ProfileCount: len(profileList),
+// ResultPath is where a profile made by the collector is uploaded, unlike
+// ingest.UploadPath it is derived from the profile id alone, as such profiles have
+// no metadata to look up.
+func ResultPath(pathPrefix, profileID string) string {
+	var date string
+	if id, err := primitive.ObjectIDFromHex(profileID); err == nil {
+		date = id.Timestamp().In(cn).Format("2006-01-02")
 	}
-	c.AbortWithStatusJSON(http.StatusOK, resp)
+	return fmt.Sprintf("%s/result/%s/%s",
+		pathPrefix,
+		date,
+		profileID)
 }
 
-func downloadPath(bucket, endPoint, objectName string) string {
-	return fmt.Sprintf("https://%s.%s/%s",
-		bucket,
-		endPoint,
-		objectName)
+// getProfileList downloads and parses the profiles concurrently.
+func getProfileList(blobStore storage.BlobStore, profileModelList []*profilemodel.Model) ([]*gprofile.Profile, error) {
+	var (
+		wg          sync.WaitGroup
+		mu          sync.Mutex
+		firstErr    error
+		profileList = make([]*gprofile.Profile, 0, len(profileModelList))
+		sem         = make(chan struct{}, runtime.NumCPU()*2)
+	)
+	for _, profileModel := range profileModelList {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(objectName string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			b, err := blobStore.Get(objectName)
+			if err != nil {
+				err = fmt.Errorf("fail to get object %s: %w", objectName, err)
+			}
+			var pprofProfile *gprofile.Profile
+			if err == nil {
+				pprofProfile, err = gprofile.ParseData(b)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			profileList = append(profileList, pprofProfile)
+		}(profileModel.ObjectName)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return profileList, nil
 }
 
 const limit = int32(100)
 
-// getProfileModelList batch get profile model from tableStore
-func getProfileModelList(tb *env.TablestoreClient, req MergeProfileReq) ([]*profilemodel.Model, error) {
-	result, total, err := searchProfile(tb, req, 0)
+// getProfileModelList batch get profile model of a tenant from the meta index
+func getProfileModelList(metaIndex storage.MetaIndex, tenant string, req MergeProfileReq) ([]*profilemodel.Model, error) {
+	if len(req.Host) == 0 && len(req.Service) == 0 {
+		return nil, errors.New("lack of host or service")
+	}
+	if len(req.ProfileType) == 0 {
+		return nil, errors.New("lack of profile_type")
+	}
+	if req.StartTime > req.EndTime {
+		return nil, errors.New("time range wrong")
+	}
+
+	q := req.query()
+	q.Tenant = tenant
+	result, total, err := metaIndex.Search(q, 0, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -325,7 +339,7 @@ func getProfileModelList(tb *env.TablestoreClient, req MergeProfileReq) ([]*prof
 	var offset = limit
 	var totalSize int64
 	for {
-		list, _, err := searchProfile(tb, req, offset)
+		list, _, err := metaIndex.Search(q, offset, limit)
 		if err != nil {
 			return nil, err
 		}
@@ -349,127 +363,3 @@ func getProfileModelList(tb *env.TablestoreClient, req MergeProfileReq) ([]*prof
 
 	return result, nil
 }
-
-// searchProfile search profile model from tableStore with request body
-func searchProfile(tb *env.TablestoreClient, req MergeProfileReq, offset int32) ([]*profilemodel.Model, int64, error) {
-	if len(req.Service) > 0 {
-
-	}
-	if len(req.Host) == 0 {
-		return nil, 0, errors.New("lack of host")
-	}
-	if len(req.ProfileType) == 0 {
-		return nil, 0, errors.New("lack of profile_type")
-	}
-	if req.StartTime > req.EndTime {
-		return nil, 0, errors.New("time range wrong")
-	}
-
-	boolQuery := search.BoolQuery{
-		MustQueries: []search.Query{
-			&search.TermQuery{
-				FieldName: profilemodel.ProfileType,
-				Term:      req.ProfileType,
-			},
-			&search.RangeQuery{
-				FieldName:    profilemodel.CreateTime,
-				From:         req.StartTime,
-				To:           req.EndTime,
-				IncludeLower: true,
-				IncludeUpper: true,
-			},
-		},
-	}
-
-	if len(req.Service) > 0 {
-		boolQuery.MustQueries = append(boolQuery.MustQueries,
-			&search.TermQuery{
-				FieldName: profilemodel.Service,
-				Term:      req.Service,
-			},
-		)
-	}
-
-	if len(req.Ip) > 0 {
-		boolQuery.MustQueries = append(boolQuery.MustQueries,
-			&search.TermQuery{
-				FieldName: profilemodel.IP,
-				Term:      req.Ip,
-			},
-		)
-	}
-
-	if len(req.Host) > 0 {
-		boolQuery.MustQueries = append(boolQuery.MustQueries,
-			&search.TermQuery{
-				FieldName: profilemodel.Host,
-				Term:      req.Host,
-			},
-		)
-	}
-
-	sort := search.Sort{
-		Sorters: []search.Sorter{
-			&search.FieldSort{
-				FieldName: profilemodel.CreateTime,
-				Order:     search.SortOrder_ASC.Enum(),
-			},
-		},
-	}
-
-	searchQuery := search.NewSearchQuery()
-	searchQuery.SetLimit(limit)
-	searchQuery.SetQuery(&boolQuery)
-	searchQuery.SetSort(&sort)
-	if offset > 0 {
-		searchQuery.SetOffset(offset)
-	} else {
-		searchQuery.SetGetTotalCount(true)
-	}
-
-	searchRequest := new(tablestore.SearchRequest)
-	searchRequest.SetTableName(tb.TableName)
-	searchRequest.SetIndexName(tb.TableName + "_idx")
-	searchRequest.SetColumnsToGet(&tablestore.ColumnsToGet{ReturnAll: true})
-	searchRequest.SetSearchQuery(searchQuery)
-
-	getRangeResp, err := tb.Client.Search(searchRequest)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	var result []*profilemodel.Model
-	for _, row := range getRangeResp.Rows {
-		profileModel := unMarshalProfileRow(row)
-		result = append(result, profileModel)
-	}
-	return result, getRangeResp.TotalCount, nil
-}
-
-// unMarshalProfileRow unmarshal information from tableStore row
-func unMarshalProfileRow(row *tablestore.Row) *profilemodel.Model {
-	result := new(profilemodel.Model)
-	ve := reflect.ValueOf(result).Elem()
-	te := reflect.TypeOf(result).Elem()
-	ret := make(map[string]reflect.Value)
-	for i := 0; i < te.NumField(); i++ {
-		tag := te.Field(i).Tag.Get("ots")
-		ret[tag] = ve.Field(i)
-	}
-	for _, column := range row.Columns {
-		v, ok := ret[column.ColumnName]
-		if !ok {
-			continue
-		}
-		if !v.IsValid() || !v.CanSet() {
-			continue
-		}
-		switch v.Kind() {
-		case reflect.String:
-			v.SetString(column.Value.(string))
-		case reflect.Int64:
-			v.SetInt(column.Value.(int64))
-		}
-	}
-	return result
-}
@@ -1,23 +1,29 @@
 package profile
 
 import (
+	"strings"
 	"testing"
 
-	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore"
+	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
 )
 
-func TestUploadPath(t *testing.T) {
-	t.Logf(UploadPath("abc", "bcf", "cpu", "efg"))
-}
-
-func TestUnMarshalProfileRow(t *testing.T) {
-	row := new(tablestore.Row)
-	row.Columns = append(row.Columns, &tablestore.AttributeColumn{
-		ColumnName: "profile_id",
-		Value:      "dfdfdkfmdkfkdfkdm",
-	}, &tablestore.AttributeColumn{
-		ColumnName: "size",
-		Value:      int64(64),
-	})
-	unMarshalProfileRow(row)
+func TestMergeableProfileModelList(t *testing.T) {
+	list := []*profilemodel.Model{
+		{ProfileId: "1", Host: "a", CreateTime: 1},
+		{ProfileId: "2", Host: "a", CreateTime: 2},
+		{ProfileId: "3", Host: "b", CreateTime: 1},
+		{ProfileId: "4", Host: "b", CreateTime: 2, Delta: true},
+		{ProfileId: "5", Host: "b", CreateTime: 3, Delta: true},
+	}
+	if got := mergeableProfileModelList("heap", list); len(got) != len(list) {
+		t.Fatalf("heap profiles are dropped: %d", len(got))
+	}
+	got := mergeableProfileModelList("allocs", list)
+	var ids []string
+	for _, m := range got {
+		ids = append(ids, m.ProfileId)
+	}
+	if strings.Join(ids, ",") != "2,4,5" {
+		t.Fatalf("mergeable profiles = %v, want [2 4 5]", ids)
+	}
 }
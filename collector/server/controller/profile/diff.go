@@ -0,0 +1,106 @@
+package profile
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaojiaoyu100/profiler/collector/analysis"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/server/middleware"
+	"go.uber.org/zap"
+)
+
+const defaultDiffTopN = 20
+
+type DiffProfileReq struct {
+	Base       MergeProfileReq `json:"base"`
+	Target     MergeProfileReq `json:"target"`
+	SampleType string          `json:"sample_type"` // 排序依据的sample类型，默认与go tool pprof一致
+	TopN       int             `json:"top_n"`       // 返回变化最大的函数个数
+}
+
+type diffProfileDetail struct {
+	ProfileID          string                   `json:"profile_id"`
+	Url                string                   `json:"url"`
+	BaseProfileCount   int                      `json:"base_profile_count"`
+	TargetProfileCount int                      `json:"target_profile_count"`
+	SampleType         string                   `json:"sample_type"`
+	TopList            []*analysis.FunctionDiff `json:"top_list"`
+}
+
+// DiffProfile merges a base set and a target set of profiles and responds
+// target minus base, like go tool pprof -diff_base, plus the functions
+// whose share changed most.
+func DiffProfile(c *gin.Context) {
+	logger := middleware.Env(c).Logger
+	var req DiffProfileReq
+	if err := c.BindJSON(&req); err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if req.Base.ProfileType != req.Target.ProfileType {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "profile types of base and target differ"})
+		return
+	}
+	if req.TopN <= 0 {
+		req.TopN = defaultDiffTopN
+	}
+
+	baseProfile, baseProfileCount, err := mergeProfileList(c, req.Base)
+	if err != nil {
+		logger().WithRequestId(c).Info("merge base profile err",
+			zap.Reflect("req", req),
+			zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	targetProfile, targetProfileCount, err := mergeProfileList(c, req.Target)
+	if err != nil {
+		logger().WithRequestId(c).Info("merge target profile err",
+			zap.Reflect("req", req),
+			zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	targetIndex, err := analysis.SampleIndex(targetProfile, req.SampleType)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sampleType := targetProfile.SampleType[targetIndex].Type
+	baseIndex, err := analysis.SampleIndex(baseProfile, sampleType)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	diffProfile, err := analysis.Diff(baseProfile, targetProfile)
+	if err != nil {
+		logger().WithRequestId(c).Info("profile diff err",
+			zap.Reflect("req", req),
+			zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	blobStore := middleware.Env(c).BlobStore()
+	profileID, objectName, err := saveProfile(blobStore, auth.Tenant(c.Request.Context()), diffProfile)
+	if err != nil {
+		logger().WithRequestId(c).Info("fail to upload",
+			zap.Reflect("req", req),
+			zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var resp = diffProfileDetail{
+		ProfileID:          profileID,
+		Url:                blobStore.URL(objectName),
+		BaseProfileCount:   baseProfileCount,
+		TargetProfileCount: targetProfileCount,
+		SampleType:         sampleType,
+		TopList:            analysis.TopDiff(baseProfile, targetProfile, baseIndex, targetIndex, req.TopN),
+	}
+	c.AbortWithStatusJSON(http.StatusOK, resp)
+}
@@ -0,0 +1,98 @@
+package profile
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	gprofile "github.com/google/pprof/profile"
+	"github.com/xiaojiaoyu100/profiler/collector/analysis"
+)
+
+const (
+	formatPprof      = "pprof"
+	formatFlameGraph = "flamegraph"
+	formatSpeedscope = "speedscope"
+	formatFolded     = "folded"
+	formatTop        = "top"
+)
+
+func validFormat(format string) bool {
+	switch format {
+	case "", formatPprof, formatFlameGraph, formatSpeedscope, formatFolded, formatTop:
+		return true
+	default:
+		return false
+	}
+}
+
+type flameGraphDetail struct {
+	ProfileCount int                 `json:"profile_count"`
+	SampleType   string              `json:"sample_type"`
+	Unit         string              `json:"unit"`
+	Root         *analysis.FlameNode `json:"root"`
+}
+
+// respondFormat converts a merged profile into req.Format on the fly
+// instead of uploading it.
+func respondFormat(c *gin.Context, req MergeProfileReq, p *gprofile.Profile, profileCount int) {
+	sampleIndex, err := analysis.SampleIndex(p, req.SampleType)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sampleType := p.SampleType[sampleIndex]
+
+	switch req.Format {
+	case formatFlameGraph:
+		c.AbortWithStatusJSON(http.StatusOK, flameGraphDetail{
+			ProfileCount: profileCount,
+			SampleType:   sampleType.Type,
+			Unit:         sampleType.Unit,
+			Root:         analysis.FlameGraph(p, sampleIndex),
+		})
+	case formatSpeedscope:
+		name := req.Service + " " + req.ProfileType
+		c.AbortWithStatusJSON(http.StatusOK, analysis.ToSpeedscope(p, sampleIndex, name))
+	case formatFolded:
+		buf := new(bytes.Buffer)
+		if err := analysis.WriteFolded(buf, p, sampleIndex); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+	case formatTop:
+		buf := new(bytes.Buffer)
+		rows := analysis.Top(p, sampleIndex, req.Sort, req.TopN)
+		if err := analysis.WriteTop(buf, rows, sampleType.Unit); err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", buf.Bytes())
+	}
+}
+
+type groupDetail struct {
+	ProfileCount int                   `json:"profile_count"`
+	SampleType   string                `json:"sample_type"`
+	Unit         string                `json:"unit"`
+	Label        string                `json:"label"`
+	GroupList    []analysis.LabelGroup `json:"group_list"`
+}
+
+// respondGroup responds the totals of every value of the label req.GroupBy.
+func respondGroup(c *gin.Context, req MergeProfileReq, p *gprofile.Profile, profileCount int) {
+	sampleIndex, err := analysis.SampleIndex(p, req.SampleType)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sampleType := p.SampleType[sampleIndex]
+	c.AbortWithStatusJSON(http.StatusOK, groupDetail{
+		ProfileCount: profileCount,
+		SampleType:   sampleType.Type,
+		Unit:         sampleType.Unit,
+		Label:        req.GroupBy,
+		GroupList:    analysis.GroupByLabel(p, sampleIndex, req.GroupBy),
+	})
+}
@@ -0,0 +1,77 @@
+package profile
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaojiaoyu100/profiler/collector/env"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest"
+	"github.com/xiaojiaoyu100/profiler/collector/server/middleware"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/boltindex"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/fsblob"
+	"go.uber.org/zap"
+)
+
+// newTestEnv returns an env backed by a temporary file system store and bolt index.
+func newTestEnv(t *testing.T) *env.Env {
+	t.Helper()
+	dir := t.TempDir()
+	blobStore, err := fsblob.New(filepath.Join(dir, "blob"), "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaIndex, err := boltindex.New(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		metaIndex.Close()
+	})
+	e := new(env.Env)
+	e.SetLogger(&env.Logger{Logger: zap.NewNop()})
+	e.SetBlobStore(blobStore)
+	e.SetMetaIndex(metaIndex)
+	return e
+}
+
+func newTestEngine(e *env.Env) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(middleware.InjectEnv(e))
+	Index(engine)
+	return engine
+}
+
+// testProfileData returns the goroutine profile of the test.
+func testProfileData(t *testing.T) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := pprof.Lookup("goroutine").WriteTo(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func ingestTestProfile(t *testing.T, e *env.Env, meta *ingest.Meta) string {
+	t.Helper()
+	if meta.CreateTime == 0 {
+		meta.CreateTime = time.Now().Unix()
+	}
+	profileID, err := ingest.Ingest(e.BlobStore(), e.MetaIndex(), meta, testProfileData(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profileID
+}
+
+func doRequest(engine *gin.Engine, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+	return w
+}
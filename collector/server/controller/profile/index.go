@@ -2,9 +2,18 @@ package profile
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/xiaojiaoyu100/profiler/collector/server/middleware"
 )
 
 func Index(engine *gin.Engine) {
-	engine.POST("/v1/profile", ReceiveProfile)
-	engine.POST("/v1/profile/merge", MergeProfile)
+	// Every endpoint is scoped to the tenant of the caller.
+	r := engine.Group("", middleware.Authenticate())
+	r.POST("/v1/profile", ReceiveProfile)
+	r.POST("/v2/profile", ReceiveProfileBatch)
+	r.POST("/v1/profile/merge", MergeProfile)
+	r.POST("/v1/profile/diff", DiffProfile)
+	r.GET("/v1/profile", ListProfile)
+	r.GET("/v1/profile/:profile_id", GetProfile)
+	r.GET("/ui/profile/:profile_id", RedirectProfileUI)
+	r.GET("/ui/profile/:profile_id/*action", ProfileUI)
 }
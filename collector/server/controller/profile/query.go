@@ -0,0 +1,183 @@
+package profile
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/server/middleware"
+	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultListLimit = int32(20)
+	maxListLimit     = limit
+)
+
+type ListProfileReq struct {
+	Service        string `form:"service"`
+	ServiceVersion string `form:"service_version"`
+	Host           string `form:"host"`
+	Ip             string `form:"ip"`
+	ProfileType    string `form:"profile_type"`
+	StartTime      int64  `form:"start_time"`
+	EndTime        int64  `form:"end_time"`
+	Cursor         string `form:"cursor"`
+	Limit          int32  `form:"limit"`
+	// Tag is given as key=value, values of the same key are ORed.
+	Tag []string `form:"tag"`
+}
+
+// tags turns the repeated tag parameters into the tag conditions of a query.
+func (req *ListProfileReq) tags() (map[string][]string, error) {
+	if len(req.Tag) == 0 {
+		return nil, nil
+	}
+	ret := make(map[string][]string)
+	for _, tag := range req.Tag {
+		i := strings.Index(tag, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid tag: %s", tag)
+		}
+		ret[tag[:i]] = append(ret[tag[:i]], tag[i+1:])
+	}
+	return ret, nil
+}
+
+type listProfileDetail struct {
+	ProfileList []*profilemodel.Model `json:"profile_list"`
+	Total       int64                 `json:"total"`
+	NextCursor  string                `json:"next_cursor"`
+}
+
+// encodeCursor hides the offset of the next page from the clients,
+// so the pagination can change without breaking them.
+func encodeCursor(offset int32) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(int64(offset), 10)))
+}
+
+func decodeCursor(cursor string) (int32, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.ParseInt(string(b), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	return int32(offset), nil
+}
+
+// ListProfile lists the metadata of the profiles, sorted by create time ascending.
+func ListProfile(c *gin.Context) {
+	logger := middleware.Env(c).Logger
+	var req ListProfileReq
+	if err := c.BindQuery(&req); err != nil {
+		return
+	}
+	if req.EndTime == 0 {
+		req.EndTime = time.Now().Unix()
+	}
+	if req.StartTime > req.EndTime {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "time range wrong"})
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultListLimit
+	}
+	if req.Limit > maxListLimit {
+		req.Limit = maxListLimit
+	}
+	offset, err := decodeCursor(req.Cursor)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+		return
+	}
+	tags, err := req.tags()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	q := &storage.Query{
+		Tenant:         auth.Tenant(c.Request.Context()),
+		Service:        req.Service,
+		ServiceVersion: req.ServiceVersion,
+		Host:           req.Host,
+		IP:             req.Ip,
+		ProfileType:    req.ProfileType,
+		StartTime:      req.StartTime,
+		EndTime:        req.EndTime,
+		Tags:           tags,
+	}
+	list, total, err := middleware.Env(c).MetaIndex().Search(q, offset, req.Limit)
+	if err != nil {
+		logger().WithRequestId(c).Info("list profile err",
+			zap.Reflect("req", req),
+			zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var resp = listProfileDetail{
+		ProfileList: list,
+		Total:       total,
+	}
+	if resp.ProfileList == nil {
+		resp.ProfileList = []*profilemodel.Model{}
+	}
+	if next := offset + int32(len(list)); len(list) > 0 && int64(next) < total {
+		resp.NextCursor = encodeCursor(next)
+	}
+	c.AbortWithStatusJSON(http.StatusOK, resp)
+}
+
+// GetProfile responds the raw profile, it can be read by go tool pprof directly.
+func GetProfile(c *gin.Context) {
+	logger := middleware.Env(c).Logger
+	profileID := c.Param("profile_id")
+
+	profileModel, err := getProfileModel(c, profileID)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger().WithRequestId(c).Info("get profile model err",
+			zap.String("profile_id", profileID),
+			zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	b, err := middleware.Env(c).BlobStore().Get(profileModel.ObjectName)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger().WithRequestId(c).Info("get profile err",
+			zap.String("profile_id", profileID),
+			zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pb.gz"`, path.Base(profileModel.ObjectName)))
+	c.Data(http.StatusOK, "application/octet-stream", b)
+}
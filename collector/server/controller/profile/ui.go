@@ -0,0 +1,82 @@
+package profile
+
+import (
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	gprofile "github.com/google/pprof/profile"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest"
+	"github.com/xiaojiaoyu100/profiler/collector/pprofui"
+	"github.com/xiaojiaoyu100/profiler/collector/server/middleware"
+	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+var uiServer = pprofui.New(16)
+
+// loadProfile loads a received profile or a profile made by the collector.
+func loadProfile(c *gin.Context, profileID string) ([]byte, error) {
+	blobStore := middleware.Env(c).BlobStore()
+	profileModel, err := getProfileModel(c, profileID)
+	if err == nil {
+		return blobStore.Get(profileModel.ObjectName)
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	if _, err := primitive.ObjectIDFromHex(profileID); err != nil {
+		return nil, storage.ErrNotFound
+	}
+	return blobStore.Get(ResultPath(ingest.TenantPathPrefix(blobStore.PathPrefix(), auth.Tenant(c.Request.Context())), profileID))
+}
+
+// getProfileModel gets the metadata of a profile of the tenant of the caller,
+// the profiles of the other tenants are not found.
+func getProfileModel(c *gin.Context, profileID string) (*profilemodel.Model, error) {
+	profileModel, err := middleware.Env(c).MetaIndex().Get(profileID)
+	if err != nil {
+		return nil, err
+	}
+	if profileModel.Tenant != auth.Tenant(c.Request.Context()) {
+		return nil, storage.ErrNotFound
+	}
+	return profileModel, nil
+}
+
+// RedirectProfileUI adds the trailing slash, the links of the web UI are relative.
+func RedirectProfileUI(c *gin.Context) {
+	c.Redirect(http.StatusMovedPermanently, c.Request.URL.Path+"/")
+}
+
+// ProfileUI serves the pprof web UI of a received profile or a merge result.
+func ProfileUI(c *gin.Context) {
+	logger := middleware.Env(c).Logger
+	profileID := c.Param("profile_id")
+
+	// A cache hit is not loaded again, so the cache is per tenant
+	// for the check of loadProfile to run for every tenant.
+	key := path.Join(auth.Tenant(c.Request.Context()), profileID)
+	err := uiServer.ServeHTTP(c.Writer, c.Request, key, c.Param("action"), func() (*gprofile.Profile, error) {
+		b, err := loadProfile(c, profileID)
+		if err != nil {
+			return nil, err
+		}
+		return gprofile.ParseData(b)
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger().WithRequestId(c).Info("profile ui err",
+			zap.String("profile_id", profileID),
+			zap.Error(err))
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+}
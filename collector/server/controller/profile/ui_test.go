@@ -0,0 +1,62 @@
+package profile
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestProfileUI(t *testing.T) {
+	e := newTestEnv(t)
+	engine := newTestEngine(e)
+	profileID := ingestTestProfile(t, e, &ingest.Meta{Service: "gateway", ProfileType: "heap"})
+
+	for _, path := range []string{"/top", "/flamegraph"} {
+		w := doRequest(engine, httptest.NewRequest(http.MethodGet, "/ui/profile/"+profileID+path, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: code %d", path, w.Code)
+		}
+	}
+	w := doRequest(engine, httptest.NewRequest(http.MethodGet, "/ui/profile/"+profileID+"/nothing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown page: code %d", w.Code)
+	}
+	w = doRequest(engine, httptest.NewRequest(http.MethodGet, "/ui/profile/"+primitive.NewObjectID().Hex()+"/top", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown profile: code %d", w.Code)
+	}
+}
+
+func TestProfileUITenant(t *testing.T) {
+	e := newTestEnv(t)
+	a, err := auth.New([]auth.Key{
+		{Key: "key-a", Services: []string{"*"}, Tenant: "team-a"},
+		{Key: "key-b", Services: []string{"*"}, Tenant: "team-b"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetAuthenticator(a)
+	engine := newTestEngine(e)
+	profileID := ingestTestProfile(t, e, &ingest.Meta{Tenant: "team-a", Service: "gateway", ProfileType: "heap"})
+
+	get := func(key string) int {
+		r := httptest.NewRequest(http.MethodGet, "/ui/profile/"+profileID+"/top", nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		return doRequest(engine, r).Code
+	}
+	// team-a views its profile first, team-b must not get it from the cache.
+	if code := get("key-a"); code != http.StatusOK {
+		t.Fatalf("team-a: code %d", code)
+	}
+	if code := get("key-b"); code != http.StatusNotFound {
+		t.Fatalf("team-b: code %d, want %d", code, http.StatusNotFound)
+	}
+	if code := get("key-a"); code != http.StatusOK {
+		t.Fatalf("team-a again: code %d", code)
+	}
+}
@@ -0,0 +1,75 @@
+package grpcserver
+
+import (
+	"context"
+
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/env"
+	"github.com/xiaojiaoyu100/profiler/tlsutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// authenticate checks the verified client certificate or else the bearer api key
+// in the "authorization" metadata if the authentication is on, it returns ctx with the scope.
+func authenticate(ctx context.Context, e *env.Env) (context.Context, error) {
+	a := e.Authenticator()
+	if a == nil {
+		return ctx, nil
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if scope, ok := a.AuthenticateCert(tlsutil.PeerCertificate(&info.State)); ok {
+				return auth.NewContext(ctx, scope), nil
+			}
+		}
+	}
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			token = auth.BearerToken(values[0])
+		}
+	}
+	scope, ok := a.Authenticate(token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid api key or certificate")
+	}
+	return auth.NewContext(ctx, scope), nil
+}
+
+// UnaryAuthInterceptor authenticates every unary call like the Authenticate
+// middleware of the http server.
+func UnaryAuthInterceptor(e *env.Env) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, e)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStream carries the context with the scope of the key.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamAuthInterceptor authenticates every stream once it is opened.
+func StreamAuthInterceptor(e *env.Env) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), e)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
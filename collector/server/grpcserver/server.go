@@ -0,0 +1,121 @@
+package grpcserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type Setter func(server *GrpcServer) error
+
+type Option struct {
+	Addr            string
+	ShutdownTimeout time.Duration
+	TLSConfig       *tls.Config // 不为空时使用TLS
+}
+
+type GrpcServer struct {
+	option   *Option
+	server   *grpc.Server
+	running  bool
+	logger   *zap.Logger
+	register []func(s *grpc.Server)
+	opts     []grpc.ServerOption
+}
+
+func New(setters ...Setter) (*GrpcServer, error) {
+	s := &GrpcServer{}
+	for _, setter := range setters {
+		if err := setter(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.option == nil || s.option.Addr == "" {
+		return nil, errors.New("no addr provided")
+	}
+	opts := s.opts
+	if s.option.TLSConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.option.TLSConfig)))
+	}
+	s.server = grpc.NewServer(opts...)
+	for _, register := range s.register {
+		register(s.server)
+	}
+	return s, nil
+}
+
+func WithLogger(logger *zap.Logger) Setter {
+	return func(server *GrpcServer) error {
+		server.logger = logger
+		return nil
+	}
+}
+
+func WithOption(option *Option) Setter {
+	return func(server *GrpcServer) error {
+		server.option = option
+		return nil
+	}
+}
+
+// WithService registers the services of the server.
+func WithService(register func(s *grpc.Server)) Setter {
+	return func(server *GrpcServer) error {
+		server.register = append(server.register, register)
+		return nil
+	}
+}
+
+func WithServerOption(opts ...grpc.ServerOption) Setter {
+	return func(server *GrpcServer) error {
+		server.opts = append(server.opts, opts...)
+		return nil
+	}
+}
+
+func (s *GrpcServer) Running() bool {
+	if s == nil {
+		return false
+	}
+	return s.running
+}
+
+// Close stops the server gracefully, the calls still running
+// after the shutdown timeout are canceled.
+func (s *GrpcServer) Close() {
+	if s == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	ti := time.NewTimer(s.option.ShutdownTimeout)
+	defer ti.Stop()
+	select {
+	case <-stopped:
+	case <-ti.C:
+		s.server.Stop()
+	}
+	s.running = false
+}
+
+func (s *GrpcServer) Run() error {
+	lis, err := net.Listen("tcp", s.option.Addr)
+	if err != nil {
+		return err
+	}
+	s.running = true
+	go func() {
+		if err := s.server.Serve(lis); err != nil {
+			s.logger.Warn("grpc serve err", zap.Error(err))
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,113 @@
+package grpcserver
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/collector/env"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest"
+	"github.com/xiaojiaoyu100/profiler/collector/ingest/ingestpb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ProfileService is the gRPC counterpart of POST /v1/profile.
+type ProfileService struct {
+	ingestpb.UnimplementedProfileServiceServer
+	env *env.Env
+}
+
+func NewProfileService(e *env.Env) *ProfileService {
+	return &ProfileService{env: e}
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func toMeta(m *ingestpb.Meta, ip string) *ingest.Meta {
+	return &ingest.Meta{
+		Service:              m.GetService(),
+		ServiceVersion:       m.GetServiceVersion(),
+		Host:                 m.GetHost(),
+		IP:                   ip,
+		GoVersion:            m.GetGoVersion(),
+		ProfileType:          m.GetProfileType(),
+		SendTime:             m.GetSendTime(),
+		CreateTime:           m.GetCreateTime(),
+		Delta:                m.GetDelta(),
+		Tags:                 m.GetTags(),
+		Triggered:            m.GetTriggered(),
+		TriggerReason:        m.GetTriggerReason(),
+		BlockProfileRate:     m.GetBlockProfileRate(),
+		MutexProfileFraction: m.GetMutexProfileFraction(),
+		MemProfileRate:       m.GetMemProfileRate(),
+	}
+}
+
+// ingest stores a profile, it returns a status error if it fails.
+func (s *ProfileService) ingest(ctx context.Context, req *ingestpb.UploadRequest) (*ingestpb.UploadResponse, error) {
+	if req.GetMeta() == nil {
+		return nil, status.Error(codes.InvalidArgument, "no meta provided")
+	}
+	meta := toMeta(req.GetMeta(), peerIP(ctx))
+	meta.Tenant = auth.Tenant(ctx)
+	if err := meta.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !auth.AllowService(ctx, meta.Service) {
+		return nil, status.Error(codes.PermissionDenied, "service not allowed")
+	}
+	if len(req.GetProfile()) == 0 {
+		return &ingestpb.UploadResponse{}, nil
+	}
+	profileID, err := ingest.Ingest(s.env.BlobStore(), s.env.MetaIndex(), meta, req.GetProfile())
+	if err != nil {
+		s.env.Logger().Info("fail to ingest profile",
+			zap.String("service", meta.Service),
+			zap.String("service_version", meta.ServiceVersion),
+			zap.String("ip", meta.IP),
+			zap.Error(err))
+		return nil, status.Error(codes.Unavailable, "fail to ingest profile")
+	}
+	return &ingestpb.UploadResponse{ProfileId: profileID}, nil
+}
+
+func (s *ProfileService) Upload(ctx context.Context, req *ingestpb.UploadRequest) (*ingestpb.UploadResponse, error) {
+	return s.ingest(ctx, req)
+}
+
+func (s *ProfileService) UploadStream(stream ingestpb.ProfileService_UploadStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp, err := s.ingest(stream.Context(), req)
+		if err != nil {
+			st, _ := status.FromError(err)
+			resp = &ingestpb.UploadResponse{
+				Error:     st.Message(),
+				Retryable: st.Code() == codes.Unavailable,
+			}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xiaojiaoyu100/profiler/collector/auth"
+	"github.com/xiaojiaoyu100/profiler/tlsutil"
+)
+
+// Authenticate checks the verified client certificate or else the bearer api key
+// of the request if the authentication is on, the scope is put in the request
+// context, see auth.AllowService.
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a := Env(c).Authenticator()
+		if a == nil {
+			c.Next()
+			return
+		}
+		scope, ok := a.AuthenticateCert(tlsutil.PeerCertificate(c.Request.TLS))
+		if !ok {
+			scope, ok = a.Authenticate(auth.BearerToken(c.GetHeader("Authorization")))
+		}
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key or certificate"})
+			return
+		}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), scope))
+		c.Next()
+	}
+}
@@ -2,6 +2,7 @@ package profilemodel
 
 const (
 	ProfileId      = "profile_id"
+	Tenant         = "tenant"
 	Service        = "service"
 	ServiceVersion = "service_version"
 	Host           = "host"
@@ -12,18 +13,35 @@ const (
 	CreateTime     = "create_time"
 	ObjectName     = "object_name"
 	Size           = "size"
+	Tags           = "tags"
+	Delta          = "delta"
+	Triggered      = "triggered"
+	TriggerReason  = "trigger_reason"
+
+	BlockProfileRate     = "block_profile_rate"
+	MutexProfileFraction = "mutex_profile_fraction"
+	MemProfileRate       = "mem_profile_rate"
 )
 
 type Model struct {
-	ProfileId      string `ots:"profile_id"`
-	Service        string `ots:"service"`
-	ServiceVersion string `ots:"service_version"`
-	Host           string `ots:"host"`
-	IP             string `ots:"ip"`
-	GoVersion      string `ots:"go_version"`
-	ProfileType    string `ots:"profile_type"`
-	SendTime       int64  `ots:"send_time"`
-	CreateTime     int64  `ots:"create_time"`
-	ObjectName     string `ots:"object_name"`
-	Size           int64  `ots:"size"`
+	ProfileId      string            `ots:"profile_id" json:"profile_id"`
+	Tenant         string            `ots:"tenant" json:"tenant,omitempty"` // 所属租户，为空时是默认租户
+	Service        string            `ots:"service" json:"service"`
+	ServiceVersion string            `ots:"service_version" json:"service_version"`
+	Host           string            `ots:"host" json:"host"`
+	IP             string            `ots:"ip" json:"ip"`
+	GoVersion      string            `ots:"go_version" json:"go_version"`
+	ProfileType    string            `ots:"profile_type" json:"profile_type"`
+	SendTime       int64             `ots:"send_time" json:"send_time"`
+	CreateTime     int64             `ots:"create_time" json:"create_time"`
+	ObjectName     string            `ots:"object_name" json:"object_name"`
+	Size           int64             `ots:"size" json:"size"`
+	Tags           map[string]string `ots:"tags" json:"tags,omitempty"`
+	Delta          bool              `ots:"delta" json:"delta"`                             // 是否是两次快照的差值
+	Triggered      bool              `ots:"triggered" json:"triggered"`                     // 是否是指标超过阈值时触发采集的
+	TriggerReason  string            `ots:"trigger_reason" json:"trigger_reason,omitempty"` // 触发原因
+
+	BlockProfileRate     int64 `ots:"block_profile_rate" json:"block_profile_rate"`         // 采集时的runtime.SetBlockProfileRate
+	MutexProfileFraction int64 `ots:"mutex_profile_fraction" json:"mutex_profile_fraction"` // 采集时的runtime.SetMutexProfileFraction
+	MemProfileRate       int64 `ots:"mem_profile_rate" json:"mem_profile_rate"`             // 采集时的runtime.MemProfileRate
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
@@ -16,6 +17,7 @@ type Setter func(server *HttpServer) error
 type Option struct {
 	Addr            string
 	ShutdownTimeout time.Duration
+	TLSConfig       *tls.Config // 不为空时使用https
 }
 
 type HttpServer struct {
@@ -42,8 +44,9 @@ func New(setters ...Setter) (*HttpServer, error) {
 		return nil, errors.New("no engine provided")
 	}
 	srv := &http.Server{
-		Addr:    s.option.Addr,
-		Handler: s.engine,
+		Addr:      s.option.Addr,
+		Handler:   s.engine,
+		TLSConfig: s.option.TLSConfig,
 	}
 	s.server = srv
 	return s, nil
@@ -91,10 +94,17 @@ func (s *HttpServer) Close() {
 }
 
 func (s *HttpServer) Run() {
+	s.running = true
 	go func() {
-		err := s.server.ListenAndServe()
-		if err == nil {
-			s.running = true
+		var err error
+		if s.server.TLSConfig != nil {
+			// The certificates come from the tls config.
+			err = s.server.ListenAndServeTLS("", "")
+		} else {
+			err = s.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			s.logger.Warn("listen and serve err", zap.Error(err))
 		}
 	}()
 }
@@ -0,0 +1,160 @@
+package boltindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// profileBucket maps a profile id to its json encoded model.
+	profileBucket = []byte("profile")
+	// createTimeBucket maps create time + profile id to the profile id,
+	// it keeps the profiles sorted by create time for range queries.
+	createTimeBucket = []byte("create_time")
+)
+
+// Index is a storage.MetaIndex embedded in a BoltDB file.
+type Index struct {
+	db *bolt.DB
+}
+
+func New(path string) (*Index, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second * 5})
+	if err != nil {
+		return nil, fmt.Errorf("fail to open bolt db: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{profileBucket, createTimeBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("fail to create buckets: %w", err)
+	}
+	return &Index{db: db}, nil
+}
+
+// Path is the path of the BoltDB file.
+func (i *Index) Path() string {
+	return i.db.Path()
+}
+
+func (i *Index) Close() error {
+	return i.db.Close()
+}
+
+func createTimeKey(createTime int64, profileID string) []byte {
+	key := make([]byte, 8, 8+len(profileID))
+	binary.BigEndian.PutUint64(key, uint64(createTime))
+	return append(key, profileID...)
+}
+
+func (i *Index) Insert(m *profilemodel.Model) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return i.db.Update(func(tx *bolt.Tx) error {
+		profiles := tx.Bucket(profileBucket)
+		if profiles.Get([]byte(m.ProfileId)) != nil {
+			return errors.New("profile already exists")
+		}
+		if err := profiles.Put([]byte(m.ProfileId), b); err != nil {
+			return err
+		}
+		return tx.Bucket(createTimeBucket).Put(createTimeKey(m.CreateTime, m.ProfileId), []byte(m.ProfileId))
+	})
+}
+
+func (i *Index) Get(profileID string) (*profilemodel.Model, error) {
+	var b []byte
+	err := i.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(profileBucket).Get([]byte(profileID)); v != nil {
+			b = append(b, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, storage.ErrNotFound
+	}
+	m := new(profilemodel.Model)
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func match(q *storage.Query, m *profilemodel.Model) bool {
+	if m.Tenant != q.Tenant {
+		return false
+	}
+	if len(q.ProfileType) > 0 && m.ProfileType != q.ProfileType {
+		return false
+	}
+	if len(q.Service) > 0 && m.Service != q.Service {
+		return false
+	}
+	if len(q.ServiceVersion) > 0 && m.ServiceVersion != q.ServiceVersion {
+		return false
+	}
+	if len(q.IP) > 0 && m.IP != q.IP {
+		return false
+	}
+	if len(q.Host) > 0 && m.Host != q.Host {
+		return false
+	}
+	return q.MatchTags(m.Tags)
+}
+
+// Search walks the profiles in the create time range in ascending order.
+func (i *Index) Search(q *storage.Query, offset int32, limit int32) ([]*profilemodel.Model, int64, error) {
+	if q.StartTime < 0 || q.StartTime > q.EndTime {
+		return nil, 0, nil
+	}
+	var (
+		result []*profilemodel.Model
+		total  int64
+	)
+	err := i.db.View(func(tx *bolt.Tx) error {
+		profiles := tx.Bucket(profileBucket)
+		c := tx.Bucket(createTimeBucket).Cursor()
+		end := createTimeKey(q.EndTime+1, "")
+		for k, v := c.Seek(createTimeKey(q.StartTime, "")); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			b := profiles.Get(v)
+			if b == nil {
+				continue
+			}
+			m := new(profilemodel.Model)
+			if err := json.Unmarshal(b, m); err != nil {
+				return err
+			}
+			if !match(q, m) {
+				continue
+			}
+			if total >= int64(offset) && len(result) < int(limit) {
+				result = append(result, m)
+			}
+			total++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, total, nil
+}
@@ -0,0 +1,111 @@
+package boltindex
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+func TestSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	index, err := New(filepath.Join(dir, "meta.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	for i := 0; i < 10; i++ {
+		host := "a"
+		if i%2 == 1 {
+			host = "b"
+		}
+		err := index.Insert(&profilemodel.Model{
+			ProfileId:   fmt.Sprintf("id%d", i),
+			Service:     "svc",
+			Host:        host,
+			ProfileType: "cpu",
+			CreateTime:  int64(100 - i),
+			Tags:        map[string]string{"region": fmt.Sprintf("r%d", i%3)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Insert(&profilemodel.Model{ProfileId: "id0"}); err == nil {
+		t.Fatal("duplicated profile id is inserted")
+	}
+
+	m, err := index.Get("id3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Host != "b" || m.CreateTime != 97 {
+		t.Fatalf("unexpected model: %+v", m)
+	}
+	if _, err := index.Get("none"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+
+	q := &storage.Query{
+		Service:     "svc",
+		Host:        "a",
+		ProfileType: "cpu",
+		StartTime:   92,
+		EndTime:     100,
+	}
+	list, total, err := index.Search(q, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 5 {
+		t.Fatalf("total = %d, want 5", total)
+	}
+	if len(list) != 2 || list[0].ProfileId != "id6" || list[1].ProfileId != "id4" {
+		t.Fatalf("unexpected result: %+v", list)
+	}
+
+	q.Tags = map[string][]string{"region": {"r0", "r1"}}
+	list, total, err = index.Search(q, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 3 || len(list) != 3 || list[0].ProfileId != "id6" || list[1].Tags["region"] != "r1" {
+		t.Fatalf("unexpected result: %d %+v", total, list)
+	}
+
+	// The profiles of a tenant are invisible to the others.
+	err = index.Insert(&profilemodel.Model{
+		ProfileId:   "tenant0",
+		Tenant:      "team-a",
+		Service:     "svc",
+		Host:        "a",
+		ProfileType: "cpu",
+		CreateTime:  99,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Tags = nil
+	if _, total, _ := index.Search(q, 0, 10); total != 5 {
+		t.Fatalf("default tenant total = %d, want 5", total)
+	}
+	q.Tenant = "team-a"
+	list, total, err = index.Search(q, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || list[0].ProfileId != "tenant0" {
+		t.Fatalf("unexpected tenant result: %d %+v", total, list)
+	}
+}
@@ -0,0 +1,79 @@
+package fsblob
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+// Store is a storage.BlobStore keeping every object as a file under a root
+// directory, so object names like prefix/2006-01-02/service/cpu/id become the
+// same directory tree on disk.
+type Store struct {
+	root       string
+	pathPrefix string
+}
+
+func New(root, pathPrefix string) (*Store, error) {
+	if root == "" {
+		return nil, fmt.Errorf("no root dir provided")
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("fail to resolve root dir: %w", err)
+	}
+	if err := os.MkdirAll(abs, 0755); err != nil {
+		return nil, fmt.Errorf("fail to create root dir: %w", err)
+	}
+	return &Store{
+		root:       abs,
+		pathPrefix: pathPrefix,
+	}, nil
+}
+
+func (s *Store) PathPrefix() string {
+	return s.pathPrefix
+}
+
+// filePath maps an object name to a file under the root dir,
+// it never escapes the root dir.
+func (s *Store) filePath(objectName string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+objectName)))
+}
+
+func (s *Store) Put(objectName string, r io.Reader) error {
+	name := s.filePath(objectName)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+func (s *Store) Get(objectName string) ([]byte, error) {
+	b, err := ioutil.ReadFile(s.filePath(objectName))
+	if os.IsNotExist(err) {
+		return nil, storage.ErrNotFound
+	}
+	return b, err
+}
+
+func (s *Store) URL(objectName string) string {
+	return "file://" + filepath.ToSlash(s.filePath(objectName))
+}
@@ -0,0 +1,45 @@
+package fsblob
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+func TestStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "fsblob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	s, err := New(root, "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectName := "profiles/2021-06-01/svc/cpu/abc"
+	if err := s.Put(objectName, bytes.NewBufferString("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "profiles", "2021-06-01", "svc", "cpu", "abc")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Get(objectName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "data" {
+		t.Fatalf("got %q", b)
+	}
+	if _, err := s.Get("profiles/none"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	if s.filePath("../../etc/passwd") != filepath.Join(root, "etc", "passwd") {
+		t.Fatal("object name escapes the root dir")
+	}
+}
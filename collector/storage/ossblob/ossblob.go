@@ -0,0 +1,61 @@
+package ossblob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+// Store is a storage.BlobStore backed by Aliyun OSS.
+type Store struct {
+	endPoint   string
+	bucketName string
+	pathPrefix string
+	bucket     *oss.Bucket
+}
+
+func New(client *oss.Client, endPoint, bucketName, pathPrefix string) (*Store, error) {
+	bucket, err := client.Bucket(bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("new bucket err: %w", err)
+	}
+	return &Store{
+		endPoint:   endPoint,
+		bucketName: bucketName,
+		pathPrefix: pathPrefix,
+		bucket:     bucket,
+	}, nil
+}
+
+func (s *Store) PathPrefix() string {
+	return s.pathPrefix
+}
+
+func (s *Store) Put(objectName string, r io.Reader) error {
+	return s.bucket.PutObject(objectName, r)
+}
+
+func (s *Store) Get(objectName string) ([]byte, error) {
+	r, err := s.bucket.GetObject(objectName)
+	if err != nil {
+		var serviceErr oss.ServiceError
+		if errors.As(err, &serviceErr) && serviceErr.StatusCode == http.StatusNotFound {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *Store) URL(objectName string) string {
+	return fmt.Sprintf("https://%s.%s/%s",
+		s.bucketName,
+		s.endPoint,
+		objectName)
+}
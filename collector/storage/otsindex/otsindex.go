@@ -0,0 +1,299 @@
+package otsindex
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore/search"
+	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+// Index is a storage.MetaIndex backed by an Aliyun Tablestore table
+// and its search index.
+type Index struct {
+	TableName string
+	Client    *tablestore.TableStoreClient
+}
+
+func New(client *tablestore.TableStoreClient, tableName string) *Index {
+	return &Index{
+		TableName: tableName,
+		Client:    client,
+	}
+}
+
+// IndexName is the name of the search index created by script.InitOTS.
+func (i *Index) IndexName() string {
+	return i.TableName + "_idx"
+}
+
+func (i *Index) Insert(m *profilemodel.Model) error {
+	putRowRequest := new(tablestore.PutRowRequest)
+	putRowChange := new(tablestore.PutRowChange)
+	putRowChange.TableName = i.TableName
+	putPk := new(tablestore.PrimaryKey)
+	putPk.AddPrimaryKeyColumn(profilemodel.ProfileId, m.ProfileId)
+	putRowChange.PrimaryKey = putPk
+	if m.Tenant != "" {
+		putRowChange.AddColumn(profilemodel.Tenant, m.Tenant)
+	}
+	putRowChange.AddColumn(profilemodel.Service, m.Service)
+	putRowChange.AddColumn(profilemodel.ServiceVersion, m.ServiceVersion)
+	putRowChange.AddColumn(profilemodel.Host, m.Host)
+	putRowChange.AddColumn(profilemodel.IP, m.IP)
+	putRowChange.AddColumn(profilemodel.GoVersion, m.GoVersion)
+	putRowChange.AddColumn(profilemodel.ProfileType, m.ProfileType)
+	putRowChange.AddColumn(profilemodel.SendTime, m.SendTime)
+	putRowChange.AddColumn(profilemodel.CreateTime, m.CreateTime)
+	putRowChange.AddColumn(profilemodel.ObjectName, m.ObjectName)
+	putRowChange.AddColumn(profilemodel.Size, m.Size)
+	putRowChange.AddColumn(profilemodel.Delta, m.Delta)
+	putRowChange.AddColumn(profilemodel.Triggered, m.Triggered)
+	if m.TriggerReason != "" {
+		putRowChange.AddColumn(profilemodel.TriggerReason, m.TriggerReason)
+	}
+	putRowChange.AddColumn(profilemodel.BlockProfileRate, m.BlockProfileRate)
+	putRowChange.AddColumn(profilemodel.MutexProfileFraction, m.MutexProfileFraction)
+	putRowChange.AddColumn(profilemodel.MemProfileRate, m.MemProfileRate)
+	if len(m.Tags) > 0 {
+		tags, err := encodeTags(m.Tags)
+		if err != nil {
+			return err
+		}
+		putRowChange.AddColumn(profilemodel.Tags, tags)
+	}
+	putRowChange.SetCondition(tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST)
+	putRowRequest.PutRowChange = putRowChange
+	_, err := i.Client.PutRow(putRowRequest)
+	return err
+}
+
+func (i *Index) Get(profileID string) (*profilemodel.Model, error) {
+	criteria := new(tablestore.SingleRowQueryCriteria)
+	criteria.TableName = i.TableName
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn(profilemodel.ProfileId, profileID)
+	criteria.PrimaryKey = pk
+	criteria.MaxVersion = 1
+	resp, err := i.Client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: criteria})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Columns) == 0 {
+		return nil, storage.ErrNotFound
+	}
+	return unMarshalProfileRow(&tablestore.Row{
+		PrimaryKey: &resp.PrimaryKey,
+		Columns:    resp.Columns,
+	}), nil
+}
+
+// Search searches profile models from the search index.
+func (i *Index) Search(q *storage.Query, offset int32, limit int32) ([]*profilemodel.Model, int64, error) {
+	getRangeResp, err := i.Client.Search(i.searchRequest(q, offset, limit))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var result []*profilemodel.Model
+	for _, row := range getRangeResp.Rows {
+		profileModel := unMarshalProfileRow(row)
+		result = append(result, profileModel)
+	}
+	return result, getRangeResp.TotalCount, nil
+}
+
+func (i *Index) searchRequest(q *storage.Query, offset int32, limit int32) *tablestore.SearchRequest {
+	boolQuery := search.BoolQuery{
+		MustQueries: []search.Query{
+			&search.RangeQuery{
+				FieldName:    profilemodel.CreateTime,
+				From:         q.StartTime,
+				To:           q.EndTime,
+				IncludeLower: true,
+				IncludeUpper: true,
+			},
+		},
+	}
+
+	// The rows of the default tenant have no tenant column.
+	if len(q.Tenant) > 0 {
+		boolQuery.MustQueries = append(boolQuery.MustQueries,
+			&search.TermQuery{
+				FieldName: profilemodel.Tenant,
+				Term:      q.Tenant,
+			},
+		)
+	} else {
+		boolQuery.MustNotQueries = append(boolQuery.MustNotQueries,
+			&search.ExistsQuery{
+				FieldName: profilemodel.Tenant,
+			},
+		)
+	}
+
+	if len(q.ProfileType) > 0 {
+		boolQuery.MustQueries = append(boolQuery.MustQueries,
+			&search.TermQuery{
+				FieldName: profilemodel.ProfileType,
+				Term:      q.ProfileType,
+			},
+		)
+	}
+
+	if len(q.Service) > 0 {
+		boolQuery.MustQueries = append(boolQuery.MustQueries,
+			&search.TermQuery{
+				FieldName: profilemodel.Service,
+				Term:      q.Service,
+			},
+		)
+	}
+
+	if len(q.ServiceVersion) > 0 {
+		boolQuery.MustQueries = append(boolQuery.MustQueries,
+			&search.TermQuery{
+				FieldName: profilemodel.ServiceVersion,
+				Term:      q.ServiceVersion,
+			},
+		)
+	}
+
+	if len(q.IP) > 0 {
+		boolQuery.MustQueries = append(boolQuery.MustQueries,
+			&search.TermQuery{
+				FieldName: profilemodel.IP,
+				Term:      q.IP,
+			},
+		)
+	}
+
+	if len(q.Host) > 0 {
+		boolQuery.MustQueries = append(boolQuery.MustQueries,
+			&search.TermQuery{
+				FieldName: profilemodel.Host,
+				Term:      q.Host,
+			},
+		)
+	}
+
+	for k, values := range q.Tags {
+		terms := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			terms = append(terms, tagTerm(k, v))
+		}
+		boolQuery.MustQueries = append(boolQuery.MustQueries,
+			&search.TermsQuery{
+				FieldName: profilemodel.Tags,
+				Terms:     terms,
+			},
+		)
+	}
+
+	sorter := search.Sort{
+		Sorters: []search.Sorter{
+			&search.FieldSort{
+				FieldName: profilemodel.CreateTime,
+				Order:     search.SortOrder_ASC.Enum(),
+			},
+		},
+	}
+
+	searchQuery := search.NewSearchQuery()
+	searchQuery.SetLimit(limit)
+	searchQuery.SetQuery(&boolQuery)
+	searchQuery.SetSort(&sorter)
+	searchQuery.SetOffset(offset)
+	// Every page needs the total, the callers page on until it is reached.
+	searchQuery.SetGetTotalCount(true)
+
+	searchRequest := new(tablestore.SearchRequest)
+	searchRequest.SetTableName(i.TableName)
+	searchRequest.SetIndexName(i.IndexName())
+	searchRequest.SetColumnsToGet(&tablestore.ColumnsToGet{ReturnAll: true})
+	searchRequest.SetSearchQuery(searchQuery)
+	return searchRequest
+}
+
+// unMarshalProfileRow unmarshal information from tableStore row
+func unMarshalProfileRow(row *tablestore.Row) *profilemodel.Model {
+	result := new(profilemodel.Model)
+	ve := reflect.ValueOf(result).Elem()
+	te := reflect.TypeOf(result).Elem()
+	ret := make(map[string]reflect.Value)
+	for i := 0; i < te.NumField(); i++ {
+		tag := te.Field(i).Tag.Get("ots")
+		ret[tag] = ve.Field(i)
+	}
+	set := func(name string, value interface{}) {
+		v, ok := ret[name]
+		if !ok {
+			return
+		}
+		if !v.IsValid() || !v.CanSet() {
+			return
+		}
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(value.(string))
+		case reflect.Int64:
+			v.SetInt(value.(int64))
+		case reflect.Bool:
+			v.SetBool(value.(bool))
+		}
+	}
+	if row.PrimaryKey != nil {
+		for _, column := range row.PrimaryKey.PrimaryKeys {
+			set(column.ColumnName, column.Value)
+		}
+	}
+	for _, column := range row.Columns {
+		if column.ColumnName == profilemodel.Tags {
+			if s, ok := column.Value.(string); ok {
+				result.Tags = decodeTags(s)
+			}
+			continue
+		}
+		set(column.ColumnName, column.Value)
+	}
+	return result
+}
+
+// tagTerm is how a tag is kept in the tags column,
+// which is indexed as a keyword array.
+func tagTerm(k, v string) string {
+	return k + "=" + v
+}
+
+func encodeTags(tags map[string]string) (string, error) {
+	terms := make([]string, 0, len(tags))
+	for k, v := range tags {
+		terms = append(terms, tagTerm(k, v))
+	}
+	sort.Strings(terms)
+	b, err := json.Marshal(terms)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeTags(s string) map[string]string {
+	var terms []string
+	if err := json.Unmarshal([]byte(s), &terms); err != nil {
+		return nil
+	}
+	tags := make(map[string]string, len(terms))
+	for _, term := range terms {
+		i := strings.Index(term, "=")
+		if i < 0 {
+			continue
+		}
+		tags[term[:i]] = term[i+1:]
+	}
+	return tags
+}
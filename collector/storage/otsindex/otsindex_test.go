@@ -0,0 +1,57 @@
+package otsindex
+
+import (
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore/otsprotocol"
+	"github.com/golang/protobuf/proto"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+func TestUnMarshalProfileRow(t *testing.T) {
+	row := new(tablestore.Row)
+	row.PrimaryKey = new(tablestore.PrimaryKey)
+	row.PrimaryKey.AddPrimaryKeyColumn("profile_id", "dfdfdkfmdkfkdfkdm")
+	row.Columns = append(row.Columns, &tablestore.AttributeColumn{
+		ColumnName: "size",
+		Value:      int64(64),
+	})
+	tags, err := encodeTags(map[string]string{"region": "hz", "canary": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row.Columns = append(row.Columns, &tablestore.AttributeColumn{
+		ColumnName: "tags",
+		Value:      tags,
+	})
+	row.Columns = append(row.Columns, &tablestore.AttributeColumn{
+		ColumnName: "tenant",
+		Value:      "team-a",
+	})
+	m := unMarshalProfileRow(row)
+	if m.ProfileId != "dfdfdkfmdkfkdfkdm" || m.Size != 64 || m.Tags["region"] != "hz" || m.Tags["canary"] != "true" || m.Tenant != "team-a" {
+		t.Fatalf("unexpected model: %+v", m)
+	}
+}
+
+func TestSearchRequestPaging(t *testing.T) {
+	i := New(nil, "profile")
+	q := &storage.Query{Service: "gateway", StartTime: 1, EndTime: 2}
+	for page := int32(0); page < 3; page++ {
+		b, err := i.searchRequest(q, page*10, 10).SearchQuery.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sq otsprotocol.SearchQuery
+		if err := proto.Unmarshal(b, &sq); err != nil {
+			t.Fatal(err)
+		}
+		if !sq.GetGetTotalCount() {
+			t.Fatalf("page %d: total count not requested", page+1)
+		}
+		if sq.GetOffset() != page*10 || sq.GetLimit() != 10 {
+			t.Fatalf("page %d: unexpected offset %d and limit %d", page+1, sq.GetOffset(), sq.GetLimit())
+		}
+	}
+}
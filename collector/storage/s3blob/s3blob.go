@@ -0,0 +1,106 @@
+package s3blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+type Option struct {
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	AccessKeySecret string
+	Bucket          string
+	PathPrefix      string
+	UseSSL          bool
+	PathStyle       bool
+	// Transport is optional, http.DefaultTransport is used if nil.
+	Transport http.RoundTripper
+}
+
+// Store is a storage.BlobStore backed by any S3 compatible object storage,
+// such as AWS S3 and MinIO.
+type Store struct {
+	option *Option
+	client *minio.Client
+}
+
+func New(option *Option) (*Store, error) {
+	if option.Endpoint == "" {
+		return nil, errors.New("no endpoint provided")
+	}
+	if option.Bucket == "" {
+		return nil, errors.New("no bucket provided")
+	}
+	lookup := minio.BucketLookupAuto
+	if option.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(option.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(option.AccessKeyID, option.AccessKeySecret, ""),
+		Secure:       option.UseSSL,
+		Transport:    option.Transport,
+		Region:       option.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fail to create a s3 client: %w", err)
+	}
+	return &Store{
+		option: option,
+		client: client,
+	}, nil
+}
+
+func (s *Store) PathPrefix() string {
+	return s.option.PathPrefix
+}
+
+func (s *Store) Put(objectName string, r io.Reader) error {
+	// Profiles are small, reading them into memory lets the sdk upload
+	// with a single request instead of buffering for a multipart upload.
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(context.Background(), s.option.Bucket, objectName,
+		bytes.NewReader(b), int64(len(b)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+func (s *Store) Get(objectName string) ([]byte, error) {
+	obj, err := s.client.GetObject(context.Background(), s.option.Bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	b, err := ioutil.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *Store) URL(objectName string) string {
+	u := *s.client.EndpointURL()
+	if s.option.PathStyle {
+		u.Path = "/" + s.option.Bucket + "/" + objectName
+	} else {
+		u.Host = s.option.Bucket + "." + u.Host
+		u.Path = "/" + objectName
+	}
+	return u.String()
+}
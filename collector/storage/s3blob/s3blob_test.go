@@ -0,0 +1,84 @@
+package s3blob
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/xiaojiaoyu100/profiler/collector/storage"
+)
+
+// fakeS3 serves PUT and GET object requests of path style addressing.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.objects[r.URL.Path] = b
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>no such key</Message></Error>`))
+			return
+		}
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestStore(t *testing.T) {
+	srv := httptest.NewTLSServer(&fakeS3{objects: make(map[string][]byte)})
+	defer srv.Close()
+
+	s, err := New(&Option{
+		Endpoint:        strings.TrimPrefix(srv.URL, "https://"),
+		Region:          "us-east-1",
+		AccessKeyID:     "key",
+		AccessKeySecret: "secret",
+		Bucket:          "profile",
+		PathPrefix:      "prefix",
+		UseSSL:          true,
+		PathStyle:       true,
+		Transport:       srv.Client().Transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objectName := "prefix/2021-06-01/svc/cpu/abc"
+	if err := s.Put(objectName, strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Get(objectName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "data" {
+		t.Fatalf("got %q", b)
+	}
+	if _, err := s.Get("prefix/none"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	if want := srv.URL + "/profile/" + objectName; s.URL(objectName) != want {
+		t.Fatalf("url = %s, want %s", s.URL(objectName), want)
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"errors"
+	"io"
+
+	"github.com/xiaojiaoyu100/profiler/collector/server/model/profilemodel"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// BlobStore keeps the raw profile data.
+type BlobStore interface {
+	// PathPrefix is the prefix every object name of this store starts with.
+	PathPrefix() string
+	Put(objectName string, r io.Reader) error
+	// Get returns ErrNotFound if there is no such object.
+	Get(objectName string) ([]byte, error)
+	// URL returns the address where the object can be downloaded.
+	URL(objectName string) string
+}
+
+// Query describes which profiles a search should return,
+// empty fields match everything.
+type Query struct {
+	// Tenant is always matched, the empty one is the default tenant.
+	Tenant         string
+	Service        string
+	ServiceVersion string
+	Host           string
+	IP             string
+	ProfileType    string
+	StartTime      int64
+	EndTime        int64
+	// Tags maps a tag key to the values it may take,
+	// a profile must match one of the values of every key.
+	Tags map[string][]string
+}
+
+// MetaIndex keeps the metadata of every profile and makes it searchable.
+type MetaIndex interface {
+	Insert(m *profilemodel.Model) error
+	// Get returns ErrNotFound if there is no such profile.
+	Get(profileID string) (*profilemodel.Model, error)
+	// Search returns the models matching q sorted by create time ascending,
+	// together with the total count of matches.
+	Search(q *Query, offset int32, limit int32) ([]*profilemodel.Model, int64, error)
+}
+
+// MatchTags reports whether tags satisfy the tag conditions of q.
+func (q *Query) MatchTags(tags map[string]string) bool {
+	for k, values := range q.Tags {
+		v, ok := tags[k]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, value := range values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
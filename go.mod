@@ -6,15 +6,21 @@ require (
 	github.com/aliyun/aliyun-oss-go-sdk v2.1.8+incompatible
 	github.com/aliyun/aliyun-tablestore-go-sdk/v5 v5.0.6
 	github.com/baiyubin/aliyun-sts-go-sdk v0.0.0-20180326062324-cfa1a18b161f // indirect
-	github.com/cavaliercoder/grab v1.0.1-0.20201108051000-98a5bfe305ec
+	github.com/cep21/circuit/v3 v3.1.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gin-gonic/gin v1.7.2
-	github.com/golang/protobuf v1.3.3
+	github.com/golang/protobuf v1.4.2
 	github.com/google/pprof v0.0.0-20210609004039-a478d1d731e9
 	github.com/influxdata/influxdb-client-go/v2 v2.4.0
-	github.com/sirupsen/logrus v1.5.0
+	github.com/minio/minio-go/v7 v7.0.11
+	github.com/sirupsen/logrus v1.8.1
 	github.com/xiaojiaoyu100/aliyun-acm/v2 v2.2.1
 	github.com/xiaojiaoyu100/cast v1.4.0
+	go.etcd.io/bbolt v1.3.6
 	go.mongodb.org/mongo-driver v1.5.3
 	go.uber.org/zap v1.17.0
 	golang.org/x/sys v0.0.0-20210510120138-977fb7262007 // indirect
+	google.golang.org/grpc v1.38.0
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -37,3 +37,24 @@ func (t Type) String() string {
 		return fmt.Sprintf("Type: %d", t)
 	}
 }
+
+// ParseType is the reverse of Type.String, it returns TypeUnknown for unknown names.
+func ParseType(s string) Type {
+	for t := TypeCPU; t <= TypeThreadCreate; t++ {
+		if t.String() == s {
+			return t
+		}
+	}
+	return TypeUnknown
+}
+
+// Cumulative reports whether the profile counts everything since the process starts,
+// merging such snapshots double counts, their deltas should be merged instead.
+func (t Type) Cumulative() bool {
+	switch t {
+	case TypeAllocs, TypeBlock, TypeMutex:
+		return true
+	default:
+		return false
+	}
+}
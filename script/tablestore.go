@@ -4,6 +4,7 @@ import (
 	"github.com/aliyun/aliyun-tablestore-go-sdk/v5/tablestore"
 	"github.com/golang/protobuf/proto"
 	"github.com/xiaojiaoyu100/profiler/collector/env"
+	"github.com/xiaojiaoyu100/profiler/collector/storage/otsindex"
 )
 
 func InitOTS(env *env.Env) {
@@ -11,8 +12,13 @@ func InitOTS(env *env.Env) {
 
 	logger.Info("begin to create table")
 
-	client := env.TablestoreClient().Client
-	tbl := env.TablestoreClient().TableName
+	index, ok := env.MetaIndex().(*otsindex.Index)
+	if !ok {
+		logger.Info("meta index is not backed by tablestore")
+		return
+	}
+	client := index.Client
+	tbl := index.TableName
 
 	createTableRequest := new(tablestore.CreateTableRequest)
 
@@ -36,7 +42,7 @@ func InitOTS(env *env.Env) {
 	}
 	logger.Info("create table successfully")
 
-	idx := tbl + "_idx"
+	idx := index.IndexName()
 	logger.Info("begin to create index")
 
 	request := &tablestore.CreateSearchIndexRequest{}
@@ -62,6 +68,12 @@ func InitOTS(env *env.Env) {
 			Index:            proto.Bool(true),
 			EnableSortAndAgg: proto.Bool(true),
 		},
+		{
+			FieldName:        proto.String("service_version"),
+			FieldType:        tablestore.FieldType_KEYWORD,
+			Index:            proto.Bool(true),
+			EnableSortAndAgg: proto.Bool(true),
+		},
 		{
 			FieldName:        proto.String("ip"),
 			FieldType:        tablestore.FieldType_KEYWORD,
@@ -74,6 +86,19 @@ func InitOTS(env *env.Env) {
 			Index:            proto.Bool(true),
 			EnableSortAndAgg: proto.Bool(true),
 		},
+		{
+			FieldName:        proto.String("tenant"),
+			FieldType:        tablestore.FieldType_KEYWORD,
+			Index:            proto.Bool(true),
+			EnableSortAndAgg: proto.Bool(true),
+		},
+		{
+			FieldName:        proto.String("tags"),
+			FieldType:        tablestore.FieldType_KEYWORD,
+			Index:            proto.Bool(true),
+			EnableSortAndAgg: proto.Bool(true),
+			IsArray:          proto.Bool(true),
+		},
 	}
 	request.IndexSchema = &tablestore.IndexSchema{
 		FieldSchemas: schemas,
@@ -0,0 +1,231 @@
+// Package tlsutil builds the tls configs of the collector and the agent,
+// the files are reloaded once they change, so renewed certificates
+// are picked up without a restart.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often the files are checked for changes at most.
+var checkInterval = time.Second * 10
+
+type Option struct {
+	CertFile string
+	KeyFile  string
+	// CAFile verifies the peer: the client certificates on the server,
+	// the server certificate on the client, where it falls back to the system roots.
+	CAFile string
+	// RequireClientCert rejects the clients without a verified certificate,
+	// otherwise a certificate is verified only if given.
+	RequireClientCert bool
+}
+
+// files loads a set of files again once one of them changes.
+type files struct {
+	names []string
+	load  func() error
+
+	mu        sync.Mutex
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func newFiles(load func() error, names ...string) (*files, error) {
+	f := &files{
+		names:    names,
+		load:     load,
+		modTimes: make([]time.Time, len(names)),
+	}
+	if _, err := f.changed(); err != nil {
+		return nil, err
+	}
+	if err := load(); err != nil {
+		return nil, err
+	}
+	f.lastCheck = time.Now()
+	return f, nil
+}
+
+// changed updates the modification times, it reports whether any changes.
+func (f *files) changed() (bool, error) {
+	changed := false
+	for i, name := range f.names {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return false, err
+		}
+		if !info.ModTime().Equal(f.modTimes[i]) {
+			f.modTimes[i] = info.ModTime()
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// reload loads the files again if they change, the loaded ones
+// are kept if it fails, e.g. while a file is half written.
+func (f *files) reload() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.lastCheck) < checkInterval {
+		return
+	}
+	f.lastCheck = time.Now()
+	changed, err := f.changed()
+	if err != nil || !changed {
+		return
+	}
+	if err := f.load(); err != nil {
+		// Try again at the next check.
+		f.modTimes = make([]time.Time, len(f.names))
+	}
+}
+
+func loadCertPool(name string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in %s", name)
+	}
+	return pool, nil
+}
+
+// ServerConfig returns the tls config of a server, a client certificate
+// is verified if CAFile is given.
+func ServerConfig(o *Option) (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("no cert or key file provided")
+	}
+	if o.RequireClientCert && o.CAFile == "" {
+		return nil, errors.New("no ca file provided to verify the client certificates")
+	}
+	var (
+		mu  sync.RWMutex
+		cfg *tls.Config
+	)
+	load := func() error {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return err
+		}
+		c := &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+			// The servers clone the outer config to add h2, which the inner one misses.
+			NextProtos: []string{"h2", "http/1.1"},
+		}
+		if o.CAFile != "" {
+			pool, err := loadCertPool(o.CAFile)
+			if err != nil {
+				return err
+			}
+			c.ClientCAs = pool
+			c.ClientAuth = tls.VerifyClientCertIfGiven
+			if o.RequireClientCert {
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+		mu.Lock()
+		cfg = c
+		mu.Unlock()
+		return nil
+	}
+	f, err := newFiles(load, o.CertFile, o.KeyFile, o.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			f.reload()
+			mu.RLock()
+			defer mu.RUnlock()
+			return cfg, nil
+		},
+	}, nil
+}
+
+// ClientConfig returns the tls config of a client, the client certificate
+// is sent if CertFile and KeyFile are given.
+func ClientConfig(o *Option) (*tls.Config, error) {
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("cert and key files must be given together")
+	}
+	c := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if o.CAFile != "" {
+		pool, err := loadCertPool(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	if o.CertFile == "" {
+		return c, nil
+	}
+	var (
+		mu   sync.RWMutex
+		cert tls.Certificate
+	)
+	load := func() error {
+		kp, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		cert = kp
+		mu.Unlock()
+		return nil
+	}
+	f, err := newFiles(load, o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	c.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		f.reload()
+		mu.RLock()
+		defer mu.RUnlock()
+		return &cert, nil
+	}
+	return c, nil
+}
+
+// PeerCertificate returns the verified certificate of the peer, nil if there is none.
+func PeerCertificate(state *tls.ConnectionState) *x509.Certificate {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// Identities returns the names a certificate identifies: the uri SANs like
+// spiffe://cluster/ns/default/sa/gateway, the dns SANs and the common name.
+func Identities(cert *x509.Certificate) []string {
+	if cert == nil {
+		return nil
+	}
+	var ret []string
+	for _, u := range cert.URIs {
+		ret = append(ret, u.String())
+	}
+	ret = append(ret, cert.DNSNames...)
+	if cert.Subject.CommonName != "" {
+		ret = append(ret, cert.Subject.CommonName)
+	}
+	return ret
+}
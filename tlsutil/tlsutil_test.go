@@ -0,0 +1,157 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCert issues a certificate by parent, a self-signed one if parent is nil.
+func newCert(t *testing.T, serial int64, tmpl *x509.Certificate, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(serial)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	issuer, issuerKey := tmpl, key
+	if parent != nil {
+		issuer, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string) {
+	err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyFile == "" {
+		return
+	}
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	ca := newCert(t, 1, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	ca.write(t, file("ca.pem"), "")
+	serverTmpl := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "collector"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+	}
+	newCert(t, 2, serverTmpl(), ca).write(t, file("server.pem"), file("server.key"))
+	u, _ := url.Parse("spiffe://cluster/sa/gateway")
+	newCert(t, 3, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "gateway"},
+		URIs:        []*url.URL{u},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca).write(t, file("client.pem"), file("client.key"))
+
+	serverConfig, err := ServerConfig(&Option{
+		CertFile:          file("server.pem"),
+		KeyFile:           file("server.key"),
+		CAFile:            file("ca.pem"),
+		RequireClientCert: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var identities []string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identities = Identities(PeerCertificate(r.TLS))
+	}))
+	srv.TLS = serverConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(o *Option) (*http.Response, error) {
+		cfg, err := ClientConfig(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		defer client.CloseIdleConnections()
+		return client.Get(srv.URL)
+	}
+
+	resp, err := get(&Option{CAFile: file("ca.pem"), CertFile: file("client.pem"), KeyFile: file("client.key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(identities) != 2 || identities[0] != u.String() || identities[1] != "gateway" {
+		t.Errorf("identities = %v", identities)
+	}
+	if resp.TLS.PeerCertificates[0].SerialNumber.Int64() != 2 {
+		t.Errorf("serial = %v", resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	if resp, err := get(&Option{CAFile: file("ca.pem")}); err == nil {
+		resp.Body.Close()
+		t.Error("no error without a client certificate")
+	}
+
+	// A renewed certificate is used without a restart.
+	checkInterval = 0
+	defer func() { checkInterval = time.Second * 10 }()
+	time.Sleep(10 * time.Millisecond)
+	newCert(t, 4, serverTmpl(), ca).write(t, file("server.pem"), file("server.key"))
+	resp, err = get(&Option{CAFile: file("ca.pem"), CertFile: file("client.pem"), KeyFile: file("client.key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.TLS.PeerCertificates[0].SerialNumber.Int64() != 4 {
+		t.Errorf("serial = %v after the renewal", resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+}